@@ -1,5 +1,7 @@
 module github.com/kuuland/shino
 
+go 1.13
+
 require (
 	github.com/fatih/color v1.7.0
 	github.com/fsnotify/fsnotify v1.4.7
@@ -0,0 +1,42 @@
+package internal
+
+import (
+	"errors"
+	"os"
+	"path"
+)
+
+// clean 删除工作目录中的base、merged及依赖目录
+func clean(base, merged, deps bool) error {
+	var dirs []string
+	if deps && !merged {
+		dirs = append(dirs, path.Join(workMergedDir, "node_modules"))
+	}
+	if deps || merged {
+		dirs = append(dirs, workInstallSumFile)
+	}
+	if merged {
+		dirs = append(dirs, workMergedDir, workManifestFile)
+	}
+	if base {
+		dirs = append(dirs, workBaseDir, workLayersDir)
+	}
+	if len(dirs) == 0 {
+		return errors.New("nothing to clean, use --base, --merged, --deps or --all")
+	}
+	for _, dir := range dirs {
+		if err := checkSafeDir(dir); err != nil {
+			return err
+		}
+	}
+	for _, dir := range dirs {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			continue
+		}
+		successPrint("%s remove: %s\n", outputFlag, dir)
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,21 +2,18 @@ package internal
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"github.com/fatih/color"
 	"github.com/fsnotify/fsnotify"
 	"github.com/urfave/cli"
-	"io/ioutil"
-	"log"
 	"os"
 	"os/exec"
 	"os/signal"
-	"os/user"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"syscall"
+	"time"
 )
 
 func RunLocal() {
@@ -24,17 +21,13 @@ func RunLocal() {
 	app.Name = "shino"
 	app.Usage = "CLI for Kuu"
 	app.Version = "0.1.4"
+	app.Flags = logFlags()
+	app.Before = applyLogFlags
 	app.Commands = cli.Commands{
 		{
 			Name:  "up",
 			Usage: "startup project",
-			Flags: []cli.Flag{
-				cli.StringFlag{
-					Name:   "base",
-					Usage:  "base project",
-					Value:  baseURL,
-					EnvVar: "BASE",
-				},
+			Flags: append(baseFlags(),
 				cli.StringFlag{
 					Name:   "install",
 					Usage:  "install command",
@@ -47,33 +40,86 @@ func RunLocal() {
 					Value:  "npm start",
 					EnvVar: "START",
 				},
-				cli.StringFlag{
-					Name:   "sync",
-					Usage:  "sync dir",
-					Value:  cwd(),
-					EnvVar: "SYNC",
+				preserveMtimeFlag,
+				cli.DurationFlag{
+					Name:   "debounce",
+					Usage:  "time to wait for file changes to settle before syncing",
+					Value:  debounce,
+					EnvVar: "DEBOUNCE",
+				},
+				cli.BoolFlag{
+					Name:   "warn-overrides",
+					Usage:  "warn about sync files overriding changed base files",
+					EnvVar: "WARN_OVERRIDES",
 				},
+			),
+			Action: func(c *cli.Context) error {
+				applyFlags(c)
+				warnOverridesFlag = c.Bool("warn-overrides")
+				return localSetup()
 			},
-			Action: func(c *cli.Context) {
-				baseVal := c.String("base")
-				installVal := c.String("install")
-				startVal := c.String("start")
-				syncVal := c.String("sync")
-
-				if baseVal != "" {
-					baseURL = strings.TrimSpace(baseVal)
-				}
-				if installVal != "" {
-					installCmd = strings.TrimSpace(installVal)
-				}
-				if startVal != "" {
-					startCmd = strings.TrimSpace(startVal)
-				}
-				if syncVal != "" {
-					syncDir = strings.TrimSpace(syncVal)
+		},
+		{
+			Name:  "update",
+			Usage: "update base project",
+			Flags: append(baseFlags(), preserveMtimeFlag),
+			Action: func(c *cli.Context) error {
+				applyFlags(c)
+				return updateSetup()
+			},
+		},
+		{
+			Name:      "run",
+			Usage:     "run a task defined in kuu.json",
+			ArgsUsage: "<task>",
+			Flags:     baseFlags(),
+			Action: func(c *cli.Context) error {
+				applyFlags(c)
+				return runSetup(c.Args().First())
+			},
+		},
+		{
+			Name:      "overrides",
+			Usage:     "list sync files overriding base files",
+			ArgsUsage: "[paths...]",
+			Flags: append(baseFlags(),
+				cli.BoolFlag{
+					Name:  "ack",
+					Usage: "mark overrides as reviewed",
+				},
+			),
+			Action: func(c *cli.Context) error {
+				applyFlags(c)
+				if err := parseConfigFile(); err != nil {
+					return err
 				}
-
-				localSetup()
+				return listOverrides(c.Bool("ack"), c.Args())
+			},
+		},
+		{
+			Name:  "clean",
+			Usage: "remove work dirs",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "base",
+					Usage: "remove base project and layer dirs",
+				},
+				cli.BoolFlag{
+					Name:  "merged",
+					Usage: "remove merged dir, including node_modules",
+				},
+				cli.BoolFlag{
+					Name:  "deps",
+					Usage: "remove node_modules in merged dir",
+				},
+				cli.BoolFlag{
+					Name:  "all",
+					Usage: "remove all work dirs",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				all := c.Bool("all")
+				return clean(all || c.Bool("base"), all || c.Bool("merged"), all || c.Bool("deps"))
 			},
 		},
 		{
@@ -94,8 +140,7 @@ func RunLocal() {
 						},
 					},
 					Action: func(c *cli.Context) error {
-						fanoTable(c.String("meta"), c.String("out"))
-						return nil
+						return fanoTable(c.String("meta"), c.String("out"))
 					},
 				},
 			},
@@ -103,43 +148,184 @@ func RunLocal() {
 	}
 
 	if err := app.Run(os.Args); err != nil {
-		log.Fatal(err)
+		exitWithError(err)
 	}
 }
 
-func parseConfigFile() {
-	var cfg map[string]string
-	cfgFile := "kuu.json"
-	if stat, err := os.Stat(cfgFile); err == nil && !stat.IsDir() {
-		if data, err := ioutil.ReadFile(cfgFile); err == nil {
-			if err := json.Unmarshal(data, &cfg); err != nil {
-				log.Println(err)
-			}
-		}
-	} else {
-		return
+// logFlags 日志相关的全局参数
+func logFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:   "log-format",
+			Usage:  "log format, text or json",
+			Value:  logFormatText,
+			EnvVar: "SHINO_LOG_FORMAT",
+		},
+		cli.BoolFlag{
+			Name:   "no-color",
+			Usage:  "disable colored output",
+			EnvVar: "SHINO_NO_COLOR",
+		},
+		cli.StringFlag{
+			Name:   "log-level",
+			Usage:  "minimum log level: debug, info, warn or error",
+			EnvVar: "SHINO_LOG_LEVEL",
+		},
+		cli.BoolFlag{
+			Name:  "verbose",
+			Usage: "show debug logs, same as --log-level debug",
+		},
+		cli.BoolFlag{
+			Name:  "quiet, q",
+			Usage: "only show warnings and errors, same as --log-level warn",
+		},
 	}
-	if cfg != nil {
-		if v, ok := cfg["base"]; ok {
-			baseURL = strings.TrimSpace(v)
+}
+
+func applyLogFlags(c *cli.Context) error {
+	if err := setLogLevel(c.GlobalString("log-level"), c.GlobalBool("verbose"), c.GlobalBool("quiet")); err != nil {
+		return err
+	}
+	return setLogFormat(c.GlobalString("log-format"), c.GlobalBool("no-color"))
+}
+
+var preserveMtimeFlag = cli.BoolFlag{
+	Name:   "preserve-mtime",
+	Usage:  "preserve modification times of merged files",
+	EnvVar: "PRESERVE_MTIME",
+}
+
+func baseFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:   "base",
+			Usage:  "base project",
+			Value:  baseURL,
+			EnvVar: "BASE",
+		},
+		cli.StringFlag{
+			Name:   "base-ref",
+			Usage:  "branch, tag or commit SHA of base project",
+			EnvVar: "BASE_REF",
+		},
+		cli.StringFlag{
+			Name:   "sync",
+			Usage:  "sync dir",
+			Value:  cwd(),
+			EnvVar: "SYNC",
+		},
+	}
+}
+
+func applyFlags(c *cli.Context) {
+	baseVal := c.String("base")
+	baseRefVal := c.String("base-ref")
+	installVal := c.String("install")
+	startVal := c.String("start")
+	syncVal := c.String("sync")
+
+	if baseVal != "" {
+		baseURL = strings.TrimSpace(baseVal)
+	}
+	if c.IsSet("base-ref") {
+		baseRef = strings.TrimSpace(baseRefVal)
+		baseRefSet = true
+	}
+	if installVal != "" {
+		installCmd = strings.TrimSpace(installVal)
+	}
+	if startVal != "" {
+		startCmd = strings.TrimSpace(startVal)
+	}
+	if syncVal != "" {
+		syncDir = strings.TrimSpace(syncVal)
+	}
+	if c.Bool("preserve-mtime") {
+		preserveMtime = true
+	}
+	if v := c.Duration("debounce"); v > 0 {
+		debounce = v
+	}
+}
+
+func localSetup() error {
+	if err := parseConfigFile(); err != nil {
+		return err
+	}
+	ctx, cancel := signalContext()
+	defer cancel()
+	lock, err := readLock(lockFile)
+	if err != nil {
+		return configError("read "+lockFile, err)
+	}
+	if err := prepareMerged(lock); err != nil {
+		return err
+	}
+	// 首次启动时记录base版本
+	if lock == nil || lock.Sha == "" {
+		if lock, err = refreshLock(lock, baseURL, baseRef, workBaseDir, workManifestFile); err != nil {
+			return mergeError("write "+lockFile, err)
 		}
-		if v, ok := cfg["install"]; ok {
-			installCmd = strings.TrimSpace(v)
+	}
+	if warnOverridesFlag {
+		warnOverrides(lock)
+	}
+	// 执行install命令
+	if ok, err := needInstall(); err != nil {
+		return mergeError("check dependencies", err)
+	} else if ok {
+		if err := install(ctx); err != nil {
+			return commandError("install", err)
 		}
-		if v, ok := cfg["start"]; ok {
-			startCmd = strings.TrimSpace(v)
+	}
+	// 执行start命令及其他常驻进程，异常退出时按配置处理
+	configs, err := checkProcesses()
+	if err != nil {
+		return configError("check processes", err)
+	}
+	sup := newProcessGroup(ctx, configs)
+	code := make(chan int, 1)
+	go func() {
+		// 只有收到退出信号或所有进程都已结束时才会返回
+		code <- sup.run()
+		cancel()
+	}()
+	go listenRestartKey(sup)
+	// 启动监听器，依赖文件变化时重新安装，配置文件变化时重启start命令
+	err = registerWatcher(ctx, func(result mergeResult) {
+		if depsChanged(result) {
+			if ok, err := needInstall(); err != nil {
+				errorPrint("%s %v\n", outputFlag, err)
+			} else if ok {
+				if err := install(ctx); err != nil {
+					errorPrint("%s install failed: %v\n", outputFlag, err)
+					return
+				}
+				sup.restartStart()
+				return
+			}
 		}
-		if v, ok := cfg["sync"]; ok {
-			syncDir = strings.TrimSpace(v)
+		if needRestart(result) {
+			sup.restartStart()
 		}
+	})
+	if err != nil {
+		cancel()
+		<-code
+		return mergeError("watch files", err)
+	}
+	// 等待子进程结束后以其退出码退出
+	if c := <-code; c != 0 {
+		return exitStatus(c)
 	}
+	return nil
 }
 
-func localSetup() {
-	parseConfigFile()
+// signalContext 收到退出信号时取消返回的context
+func signalContext() (context.Context, context.CancelFunc) {
 	ctx, cancel := context.WithCancel(context.Background())
 	//创建监听退出chan
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	//监听指定信号 ctrl+c kill
 	signal.Notify(c, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	go func() {
@@ -153,129 +339,319 @@ func localSetup() {
 			}
 		}
 	}()
+	return ctx, cancel
+}
+
+// prepareMerged 按锁定版本准备base及中间层，并合并到.shino/merged
+func prepareMerged(lock *Lock) error {
+	ref := lockedRef(lock, baseURL, baseRef)
 	// 检查是否存在.shino/base目录
 	if isEmptyDir(workBaseDir) {
-		ensureDir(workBaseDir)
+		if err := ensureDir(workBaseDir); err != nil {
+			return mergeError("create "+workBaseDir, err)
+		}
 		// 执行clone命令
-		cloneCmd := clone(baseURL, workBaseDir)
-		execCmd(cloneCmd)
+		if err := clone(baseURL, ref, workBaseDir); err != nil {
+			return gitError("clone "+baseURL, err)
+		}
+	} else if isCommitSHA(ref) {
+		// 已有的base与锁定版本不一致时重新检出
+		if head, err := headCommit(workBaseDir); err == nil && head != ref {
+			if err := checkoutCommit(workBaseDir, ref); err != nil {
+				return gitError("checkout "+ref, err)
+			}
+		}
 	}
-	// 执行合并：.shino/base + sync = .shino/merged
-	if isEmptyDir(workMergedDir) {
-		execMerge()
+	// 克隆git中间层
+	if err := prepareOverlays(false); err != nil {
+		return gitError("prepare layers", err)
 	}
-	// 执行install命令
-	if installCmd != "" && isEmptyDir(path.Join(workMergedDir, "node_modules")) {
-		installCmd := mergedCmd(ctx, installCmd)
-		execCmd(installCmd)
-	}
-	// 执行start命令
-	cmd := mergedCmd(ctx, startCmd)
-	go execCmd(cmd)
-	// 启动监听器
-	registerWatcher()
+	// 执行合并：.shino/base + layers + sync = .shino/merged
+	return execMerge()
+}
+
+// runSetup 合并后在合并目录中执行任务，未指定任务时列出所有任务
+func runSetup(name string) error {
+	if err := parseConfigFile(); err != nil {
+		return err
+	}
+	if name == "" {
+		listTasks()
+		return nil
+	}
+	// 提前检查任务配置，避免无效任务也触发克隆和合并
+	if _, err := taskOrder(name); err != nil {
+		return configError("check tasks", err)
+	}
+	ctx, cancel := signalContext()
+	defer cancel()
+	lock, err := readLock(lockFile)
+	if err != nil {
+		return configError("read "+lockFile, err)
+	}
+	if err := prepareMerged(lock); err != nil {
+		return err
+	}
+	if err := runTask(ctx, name); err != nil {
+		// 失败原因已在runTask中输出
+		return commandError("", err)
+	}
+	return nil
+}
+
+func updateSetup() error {
+	if err := parseConfigFile(); err != nil {
+		return err
+	}
+	lock, err := readLock(lockFile)
+	if err != nil {
+		return configError("read "+lockFile, err)
+	}
+	if isEmptyDir(workBaseDir) {
+		if err := ensureDir(workBaseDir); err != nil {
+			return mergeError("create "+workBaseDir, err)
+		}
+		if err := clone(baseURL, baseRef, workBaseDir); err != nil {
+			return gitError("clone "+baseURL, err)
+		}
+	} else {
+		before, err := headCommit(workBaseDir)
+		if err != nil {
+			return gitError("update base", err)
+		}
+		// 没有锁文件时无法确定原来的ref，按ref变化处理
+		refChanged := lock == nil || lock.Base != baseURL || lock.Ref != baseRef
+		if err := updateRef(workBaseDir, baseURL, baseRef, refChanged); err != nil {
+			return gitError("update base", err)
+		}
+		after, err := headCommit(workBaseDir)
+		if err != nil {
+			return gitError("update base", err)
+		}
+		if before == after {
+			successPrint("%s base is up to date: %s\n", outputFlag, after)
+		} else {
+			successPrint("%s base updated: %s => %s\n", outputFlag, before, after)
+		}
+	}
+	if err := prepareOverlays(true); err != nil {
+		return gitError("update layers", err)
+	}
+	// 增量合并，保留merged中的node_modules
+	if err := execMerge(); err != nil {
+		return err
+	}
+	if _, err := refreshLock(lock, baseURL, baseRef, workBaseDir, workManifestFile); err != nil {
+		return mergeError("write "+lockFile, err)
+	}
+	return nil
 }
 
-func mergedCmd(ctx context.Context, execStr string) *exec.Cmd {
-	args := strings.Split(execStr, " ")
-	cmd := exec.CommandContext(ctx, args[0])
-	if len(args) > 1 {
-		cmd.Args = args
+// mergedCmd 创建在合并目录中执行的命令
+func mergedCmd(execStr string) (*exec.Cmd, error) {
+	cmd, err := commandOf(execStr, nil)
+	if err != nil {
+		return nil, fmt.Errorf("parse command %q: %w", execStr, err)
 	}
 	cmd.Dir = workMergedDir
-	return cmd
+	return cmd, nil
 }
 
 func errorPrint(format string, a ...interface{}) {
-	if _, err := color.New(color.FgHiRed, color.Bold).Printf(format, a...); err != nil {
-		log.Println(err)
-	}
+	logWith(levelError, nil, format, a...)
 }
 
-func execMerge() {
+func execMerge() error {
 	successPrint("%s merge dirs\n", outputFlag)
-	safeDirs := []string{"", ".", "/", "/usr", os.TempDir()}
-	if v, err := os.UserCacheDir(); err == nil {
-		safeDirs = append(safeDirs, v)
+	if err := checkSafeDir(workMergedDir); err != nil {
+		return configError("check merged dir", err)
 	}
-	if usr, err := user.Current(); err == nil {
-		safeDirs = append(safeDirs, usr.HomeDir)
+	if err := ensureDir(workMergedDir); err != nil {
+		return mergeError("create "+workMergedDir, err)
 	}
-	for _, dir := range safeDirs {
-		if workMergedDir == dir {
-			log.Fatal(fmt.Errorf("Fatal merged dir: %s", workMergedDir))
-		}
+	// 依次合并base目录和sync目录
+	m, err := localMerger()
+	if err != nil {
+		return mergeError("read "+workManifestFile, err)
 	}
-	ensureDir(workMergedDir)
-	// 复制base目录
-	if err := copyDir(workBaseDir, workMergedDir); err != nil {
-		log.Fatal(err)
+	result, err := m.merge()
+	if err != nil {
+		return mergeError("merge dirs", err)
 	}
-	// 复制sync目录
-	destPath := destSrcCase(syncDir, workMergedDir)
-	if err := copyDir(syncDir, destPath); err != nil {
-		log.Fatal(err)
+	if err := writeManifest(workManifestFile, m.manifest); err != nil {
+		return mergeError("write "+workManifestFile, err)
 	}
+	logInfo(resultFields(result), "%s merged: %s\n", outputFlag, result)
+	return nil
 }
 
-func consumeEvent(watcher *fsnotify.Watcher, event fsnotify.Event) {
-	changedPath := event.Name
-	replacePath := strings.Replace(changedPath, syncDir, "", 1)
-	wsRealMergedPath := destSrcCase(syncDir, workMergedDir)
-	destPath := path.Join(wsRealMergedPath, replacePath)
+func localMerger() (*merger, error) {
+	files, err := readManifest(workManifestFile)
+	if err != nil {
+		return nil, err
+	}
+	return &merger{
+		layers:   localLayers(),
+		dest:     workMergedDir,
+		manifest: files,
+	}, nil
+}
 
-	switch event.Op {
-	case fsnotify.Create:
-		successPrint("%s create: %s => %s\n", outputFlag, changedPath, destPath)
+// watchEvent 及时更新监听列表，新建目录中的变更才能被监听到
+func watchEvent(watcher *fsnotify.Watcher, event fsnotify.Event) {
+	switch {
+	case event.Op&fsnotify.Create == fsnotify.Create:
 		if err := watcher.Add(event.Name); err != nil {
-			log.Println(err)
+			errorPrint("%s watch %s: %v\n", outputFlag, event.Name, err)
 		}
+	case event.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+		// 文件被删除后inotify会自动移除监听，忽略此时的错误
+		_ = watcher.Remove(event.Name)
+	}
+}
+
+// consumeEvents 批量处理合并后的文件变更，同一路径的多次变更只处理一次
+func consumeEvents(m *merger, events map[string]fsnotify.Op) (total mergeResult) {
+	// 变更文件可能同时位于多个监听目录中
+	rels := make(map[string]bool)
+	for changedPath, op := range events {
+		if op&(fsnotify.Create|fsnotify.Write|fsnotify.Remove|fsnotify.Rename) == 0 {
+			continue
+		}
+		isDir := false
 		if stat, err := os.Stat(changedPath); err == nil {
-			if stat.IsDir() {
-				ensureDir(destPath)
-			} else {
-				ensureDir(path.Dir(destPath))
-				if _, err := copyFile(changedPath, destPath); err != nil {
-					log.Println(err)
-				}
-			}
+			isDir = stat.IsDir()
 		}
-	case fsnotify.Rename, fsnotify.Remove, fsnotify.Remove | fsnotify.Rename:
-		successPrint("%s remove: %s => %s\n", outputFlag, changedPath, destPath)
-		if err := watcher.Remove(event.Name); err != nil {
-			log.Println(err)
+		for _, layer := range m.layers[1:] {
+			if layer.ignored(changedPath, isDir) {
+				continue
+			}
+			if rel, ok := layer.destRel(changedPath); ok {
+				rels[rel] = true
+			}
 		}
-		if err := os.RemoveAll(destPath); err != nil {
-			log.Println(err)
+	}
+	if len(rels) == 0 {
+		return total
+	}
+	sorted := make([]string, 0, len(rels))
+	for rel := range rels {
+		sorted = append(sorted, rel)
+	}
+	sort.Strings(sorted)
+
+	for _, rel := range sorted {
+		result, err := m.update(rel)
+		if err != nil {
+			logWith(levelError, logFields{"path": rel}, "%s sync %s: %v\n", outputFlag, path.Join(m.dest, rel), err)
+			continue
 		}
-	case fsnotify.Write:
-		successPrint("%s write: %s => %s\n", outputFlag, changedPath, destPath)
-		if _, err := copyFile(changedPath, destPath); err != nil {
-			log.Println(err)
+		for _, change := range result.Changes {
+			logDebug(logFields{"op": change.Op, "path": change.Path, "source": change.Source}, "%s %s %s\n", outputFlag, change.Op, change.Path)
 		}
+		total.add(result)
+	}
+	if err := writeManifest(workManifestFile, m.manifest); err != nil {
+		errorPrint("%s write %s: %v\n", outputFlag, workManifestFile, err)
 	}
+	fields := resultFields(total)
+	fields["events"] = len(events)
+	logDebug(fields, "%s synced %d changes: %s\n", outputFlag, len(total.Changes), total)
+	return total
 }
 
-func registerWatcher() {
+// syncSummary 累计一段时间内同步的文件，定期输出汇总
+type syncSummary struct {
+	total mergeResult
+	files map[string]bool
+}
+
+func (s *syncSummary) add(result mergeResult) {
+	if s.files == nil {
+		s.files = make(map[string]bool)
+	}
+	for _, change := range result.Changes {
+		s.files[change.Path] = true
+	}
+	s.total.add(result)
+}
+
+// flush 输出汇总并清空，没有同步文件时不输出
+func (s *syncSummary) flush() {
+	if len(s.files) == 0 {
+		return
+	}
+	fields := resultFields(s.total)
+	fields["files"] = len(s.files)
+	if len(s.files) == 1 {
+		logInfo(fields, "%s synced 1 file\n", outputFlag)
+	} else {
+		logInfo(fields, "%s synced %d files\n", outputFlag, len(s.files))
+	}
+	*s = syncSummary{}
+}
+
+// registerWatcher 监听sync目录及本地中间层，onSync在每批变更同步完成后调用，ctx取消后关闭监听器，等待正在处理的变更完成后返回
+func registerWatcher(ctx context.Context, onSync func(mergeResult)) error {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	defer func() {
+	closeWatcher := func() {
 		if err := watcher.Close(); err != nil {
-			log.Println(err)
+			errorPrint("%s close watcher: %v\n", outputFlag, err)
 		}
-	}()
+	}
 
-	done := make(chan bool)
+	m, err := localMerger()
+	if err != nil {
+		closeWatcher()
+		return err
+	}
+	for _, dir := range watchDirs() {
+		if err := watchDir(watcher, dir); err != nil {
+			closeWatcher()
+			return err
+		}
+	}
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
+		// 在防抖时间内没有新的变更时才批量处理
+		events := make(map[string]fsnotify.Op)
+		timer := time.NewTimer(debounce)
+		timer.Stop()
+		// 每批变更的详情只在debug级别输出，info级别定期输出汇总
+		var summary syncSummary
+		ticker := time.NewTicker(summaryInterval)
+		defer ticker.Stop()
 		for {
 			select {
 			case event, ok := <-watcher.Events:
 				if !ok {
+					summary.flush()
 					return
 				}
-				consumeEvent(watcher, event)
+				logDebug(logFields{"path": event.Name, "op": event.Op.String()}, "%s %s %s\n", outputFlag, strings.ToLower(event.Op.String()), event.Name)
+				watchEvent(watcher, event)
+				events[event.Name] |= event.Op
+				// 重置前先停止计时器并取出已到期的信号，避免立即处理未防抖的变更
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(debounce)
+			case <-timer.C:
+				result := consumeEvents(m, events)
+				summary.add(result)
+				if onSync != nil {
+					onSync(result)
+				}
+				events = make(map[string]fsnotify.Op)
+			case <-ticker.C:
+				summary.flush()
 			case err, ok := <-watcher.Errors:
 				if !ok {
 					return
@@ -284,28 +660,31 @@ func registerWatcher() {
 			}
 		}
 	}()
+	<-ctx.Done()
+	// 关闭监听器后等待正在处理的变更完成，避免退出时中断文件写入
+	closeWatcher()
+	<-done
+	return nil
+}
 
-	err = watcher.Add(syncDir)
-	if err != nil {
-		log.Fatal(err)
+// watchDir 监听目录及其中未被忽略的子目录
+func watchDir(watcher *fsnotify.Watcher, dir string) error {
+	if err := watcher.Add(dir); err != nil {
+		return fmt.Errorf("watch %s: %w", dir, err)
 	}
-	err = filepath.Walk(syncDir, func(path string, info os.FileInfo, err error) error {
-		if strings.Contains(path, "node_modules") {
-			return nil
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
 		}
-		if info.IsDir() {
-			if err := IsIgnoreDir(path); err != nil {
-				return err
+		if isIgnored(dir, path, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
 			}
+			return nil
 		}
-		err = watcher.Add(path)
-		if err != nil {
-			log.Fatal(err)
+		if err := watcher.Add(path); err != nil {
+			return fmt.Errorf("watch %s: %w", path, err)
 		}
 		return nil
 	})
-	if err != nil {
-		log.Fatal(err)
-	}
-	<-done
 }
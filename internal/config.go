@@ -0,0 +1,114 @@
+package internal
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+const configFile = "kuu.json"
+
+// kuuConfig kuu.json配置项
+type kuuConfig struct {
+	Base    string            `json:"base"`
+	Ref     string            `json:"ref"`
+	Install string            `json:"install"`
+	Start   string            `json:"start"`
+	Sync    string            `json:"sync"`
+	Layers  []string          `json:"layers"`
+	Mounts  map[string]string `json:"mounts"`
+	Ignore  []string          `json:"ignore"`
+	// Debounce 文件变更的防抖时间，如“300ms”
+	Debounce string `json:"debounce"`
+	// PreserveMtime 复制到合并目录的文件是否保留源文件的修改时间
+	PreserveMtime bool `json:"preserveMtime"`
+	// RestartOn 这些文件变化时重启start命令，语法同.gitignore
+	RestartOn []string `json:"restartOn"`
+	// Merge 按路径配置合并方式，如{"*.env": "dotenv-merge"}
+	Merge map[string]string `json:"merge"`
+	// Shell 为true时通过“sh -c”执行install/start命令
+	Shell bool `json:"shell"`
+	// Env 传给install/start命令的环境变量，值中的${VAR}会替换为宿主环境变量
+	Env map[string]string `json:"env"`
+	// EnvFile 传给install/start命令的dotenv文件
+	EnvFile []string `json:"envFile"`
+	// Tasks 通过“shino run <task>”执行的任务
+	Tasks map[string]taskConfig `json:"tasks"`
+	// Processes 与start命令一起运行的常驻进程
+	Processes []processConfig `json:"processes"`
+	// Ready start命令的就绪检查
+	Ready *readyConfig `json:"ready"`
+}
+
+// parseConfigFile 读取kuu.json，配置项覆盖默认值及命令行参数，显式指定的--base-ref除外
+func parseConfigFile() error {
+	var cfg kuuConfig
+	if stat, err := os.Stat(configFile); err == nil && !stat.IsDir() {
+		data, err := ioutil.ReadFile(configFile)
+		if err != nil {
+			return configError("read "+configFile, err)
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return configError("parse "+configFile, err)
+		}
+	}
+	ignore = loadIgnore(cfg.Ignore)
+	if v := strings.TrimSpace(cfg.Base); v != "" {
+		baseURL = v
+	}
+	if v := strings.TrimSpace(cfg.Ref); v != "" && !baseRefSet {
+		baseRef = v
+	}
+	if v := strings.TrimSpace(cfg.Install); v != "" {
+		installCmd = v
+	}
+	if v := strings.TrimSpace(cfg.Start); v != "" {
+		startCmd = v
+	}
+	if v := strings.TrimSpace(cfg.Sync); v != "" {
+		syncDir = v
+	}
+	if v := strings.TrimSpace(cfg.Debounce); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return configError("parse debounce", err)
+		}
+		debounce = d
+	}
+	if cfg.PreserveMtime {
+		preserveMtime = true
+	}
+	if cfg.Shell {
+		shellMode = true
+	}
+	if len(cfg.Env) > 0 {
+		cmdEnv = cfg.Env
+	}
+	if len(cfg.EnvFile) > 0 {
+		envFiles = cfg.EnvFile
+	}
+	if cfg.Ready != nil {
+		startReady = cfg.Ready
+	}
+	if len(cfg.Processes) > 0 {
+		processes = cfg.Processes
+	}
+	if len(cfg.Tasks) > 0 {
+		tasks = cfg.Tasks
+	}
+	if cfg.RestartOn != nil {
+		restartOn = cfg.RestartOn
+	}
+	if len(cfg.Merge) > 0 {
+		mergeRules = newMergeRules(cfg.Merge)
+	}
+	if len(cfg.Layers) > 0 {
+		layers = cfg.Layers
+	}
+	if len(cfg.Mounts) > 0 {
+		mounts = cfg.Mounts
+	}
+	return nil
+}
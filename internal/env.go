@@ -0,0 +1,141 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// 传给install/start命令的环境变量
+	cmdEnv map[string]string
+	// 传给install/start命令的dotenv文件，靠后的文件优先
+	envFiles []string
+
+	// 环境变量值中引用的宿主环境变量，如“${HOME}”
+	envRefPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+	// 命令字符串中的环境变量赋值
+	commandAssignPattern = regexp.MustCompile(`\b[A-Za-z_][A-Za-z0-9_]*=\S+`)
+	// 变量名包含这些关键字时输出日志会隐藏其值
+	secretEnvPattern = regexp.MustCompile(`(?i)(secret|token|password|passwd|pwd|auth|credential|private|api_?key|access_?key)`)
+)
+
+// commandEnv 读取envFile及env配置的环境变量，env中的同名变量优先，extra最优先
+func commandEnv(extra map[string]string) ([]string, error) {
+	values := make(map[string]string)
+	for _, file := range envFiles {
+		fileValues, err := readEnvFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range fileValues {
+			values[k] = v
+		}
+	}
+	for _, m := range []map[string]string{cmdEnv, extra} {
+		for k, v := range m {
+			values[k] = v
+		}
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	env := make([]string, 0, len(keys))
+	for _, k := range keys {
+		env = append(env, k+"="+expandEnv(values[k]))
+	}
+	return env, nil
+}
+
+// expandEnv 替换值中的${VAR}为宿主环境变量，未定义的变量替换为空
+func expandEnv(value string) string {
+	return envRefPattern.ReplaceAllStringFunc(value, func(ref string) string {
+		return os.Getenv(envRefPattern.FindStringSubmatch(ref)[1])
+	})
+}
+
+// readEnvFile 解析dotenv文件，支持export前缀、单双引号及行尾注释
+func readEnvFile(file string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read env file: %w", err)
+	}
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		key, ok := dotenvKey(line)
+		if !ok {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		values[key] = dotenvValue(strings.TrimSpace(line[strings.Index(line, "=")+1:]))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %s: %w", file, err)
+	}
+	return values, nil
+}
+
+// dotenvValue 去掉值两端的引号，双引号中支持\n等转义，无引号时去掉行尾注释
+func dotenvValue(raw string) string {
+	if len(raw) >= 2 {
+		switch {
+		case raw[0] == '\'' && raw[len(raw)-1] == '\'':
+			return raw[1 : len(raw)-1]
+		case raw[0] == '"' && raw[len(raw)-1] == '"':
+			return strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\"`, `"`, `\\`, `\`).Replace(raw[1 : len(raw)-1])
+		}
+	}
+	if idx := strings.Index(raw, " #"); idx >= 0 {
+		raw = raw[:idx]
+	}
+	return strings.TrimSpace(raw)
+}
+
+// maskEnv 隐藏敏感变量的值，用于输出日志
+func maskEnv(kv string) string {
+	idx := strings.Index(kv, "=")
+	if idx < 0 || !secretEnvPattern.MatchString(kv[:idx]) || idx == len(kv)-1 {
+		return kv
+	}
+	return kv[:idx+1] + "******"
+}
+
+// maskArg 隐藏形如KEY=VALUE的参数中的敏感信息
+func maskArg(arg string) string {
+	if envAssignPattern.MatchString(arg) {
+		return maskEnv(arg)
+	}
+	return arg
+}
+
+// maskCommand 隐藏命令字符串中KEY=VALUE形式的敏感信息
+func maskCommand(command string) string {
+	return commandAssignPattern.ReplaceAllStringFunc(command, maskEnv)
+}
+
+// extraEnv 返回命令中宿主环境没有的环境变量
+func extraEnv(env []string) []string {
+	if len(env) == 0 {
+		return nil
+	}
+	host := make(map[string]bool)
+	for _, kv := range os.Environ() {
+		host[kv] = true
+	}
+	var extra []string
+	for _, kv := range env {
+		if !host[kv] {
+			extra = append(extra, kv)
+		}
+	}
+	return extra
+}
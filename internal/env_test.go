@@ -0,0 +1,134 @@
+package internal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadEnvFile(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		want    map[string]string
+	}{
+		{"plain", "A=1\nB=two\n", map[string]string{"A": "1", "B": "two"}},
+		{"comments and blank lines", "# comment\n\nA=1\n  # indented\n", map[string]string{"A": "1"}},
+		{"export prefix", "export A=1\n", map[string]string{"A": "1"}},
+		{"spaces around", "  A = 1  \n", map[string]string{"A": "1"}},
+		{"empty value", "A=\n", map[string]string{"A": ""}},
+		{"value with equals", "URL=http://x?a=b\n", map[string]string{"URL": "http://x?a=b"}},
+		{"single quotes", "A='x # y \\n'\n", map[string]string{"A": `x # y \n`}},
+		{"double quotes", `A="line1\nline2 \"q\""` + "\n", map[string]string{"A": "line1\nline2 \"q\""}},
+		{"trailing comment", "A=1 # note\n", map[string]string{"A": "1"}},
+		{"hash without space", "A=a#b\n", map[string]string{"A": "a#b"}},
+		{"invalid lines", "no equals\n=1\nA=1\n", map[string]string{"A": "1"}},
+		{"later wins", "A=1\nA=2\n", map[string]string{"A": "2"}},
+		{"crlf", "A=1\r\nB=2\r\n", map[string]string{"A": "1", "B": "2"}},
+	}
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	for _, c := range cases {
+		file := filepath.Join(dir, ".env")
+		if err := ioutil.WriteFile(file, []byte(c.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readEnvFile(file)
+		if err != nil {
+			t.Errorf("%s: readEnvFile() error = %v", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: readEnvFile(%q) = %q, want %q", c.name, c.content, got, c.want)
+		}
+	}
+	if _, err := readEnvFile(filepath.Join(dir, "missing.env")); err == nil {
+		t.Error("readEnvFile() of a missing file should fail")
+	}
+}
+
+func TestDotenvValue(t *testing.T) {
+	cases := []struct {
+		raw  string
+		want string
+	}{
+		{"", ""},
+		{"value", "value"},
+		{"'single'", "single"},
+		{`"double"`, "double"},
+		{`"a\tb\\c"`, "a\tb\\c"},
+		{`'a\tb'`, `a\tb`},
+		{`"unterminated`, `"unterminated`},
+		{"'", "'"},
+		{"value # comment", "value"},
+		{"'quoted # kept'", "quoted # kept"},
+	}
+	for _, c := range cases {
+		if got := dotenvValue(c.raw); got != c.want {
+			t.Errorf("dotenvValue(%q) = %q, want %q", c.raw, got, c.want)
+		}
+	}
+}
+
+func TestMaskEnv(t *testing.T) {
+	cases := []struct {
+		kv   string
+		want string
+	}{
+		{"NODE_ENV=production", "NODE_ENV=production"},
+		{"API_TOKEN=abc", "API_TOKEN=******"},
+		{"db_password=abc", "db_password=******"},
+		{"AWS_SECRET_ACCESS_KEY=abc", "AWS_SECRET_ACCESS_KEY=******"},
+		{"APIKEY=abc", "APIKEY=******"},
+		{"API_KEY=abc", "API_KEY=******"},
+		{"TOKEN=", "TOKEN="},
+		{"TOKEN", "TOKEN"},
+		{"PATH=/a=token", "PATH=/a=token"},
+	}
+	for _, c := range cases {
+		if got := maskEnv(c.kv); got != c.want {
+			t.Errorf("maskEnv(%q) = %q, want %q", c.kv, got, c.want)
+		}
+	}
+
+	commands := []struct {
+		command string
+		want    string
+	}{
+		{"npm start", "npm start"},
+		{"NPM_TOKEN=abc npm publish", "NPM_TOKEN=****** npm publish"},
+		{"node app.js --port=3000 SECRET=x", "node app.js --port=3000 SECRET=******"},
+	}
+	for _, c := range commands {
+		if got := maskCommand(c.command); got != c.want {
+			t.Errorf("maskCommand(%q) = %q, want %q", c.command, got, c.want)
+		}
+	}
+}
+
+func TestCommandEnv(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	writeTree(t, dir, map[string]string{
+		".env":       "A=file\nB=file\nC=file\n",
+		".env.local": "B=local\n",
+	})
+	defer func(files []string, env map[string]string) {
+		envFiles, cmdEnv = files, env
+	}(envFiles, cmdEnv)
+	envFiles = []string{filepath.Join(dir, ".env"), filepath.Join(dir, ".env.local")}
+	cmdEnv = map[string]string{"C": "config", "HOME_DIR": "${SHINO_TEST_HOME}/x"}
+	os.Setenv("SHINO_TEST_HOME", "/home/test")
+	defer os.Unsetenv("SHINO_TEST_HOME")
+
+	got, err := commandEnv(map[string]string{"A": "extra"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"A=extra", "B=local", "C=config", "HOME_DIR=/home/test/x"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("commandEnv() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// 不同类型的错误对应的退出码，子进程失败时使用子进程的退出码
+const (
+	exitGeneral = 1
+	exitConfig  = 2
+	exitGit     = 3
+	exitMerge   = 4
+	exitCommand = 5
+)
+
+// stepError 记录出错的步骤及对应的退出码
+type stepError struct {
+	code int
+	step string
+	err  error
+}
+
+func (e *stepError) Error() string {
+	switch {
+	case e.err == nil:
+		return e.step
+	case e.step == "":
+		return e.err.Error()
+	}
+	return fmt.Sprintf("%s: %v", e.step, e.err)
+}
+
+func (e *stepError) Unwrap() error {
+	return e.err
+}
+
+// configError 配置文件或命令行参数错误
+func configError(step string, err error) error {
+	return &stepError{code: exitConfig, step: step, err: err}
+}
+
+// gitError 克隆或更新仓库失败
+func gitError(step string, err error) error {
+	return &stepError{code: exitGit, step: step, err: err}
+}
+
+// mergeError 合并或同步文件失败
+func mergeError(step string, err error) error {
+	return &stepError{code: exitMerge, step: step, err: err}
+}
+
+// commandError 命令无法执行，命令以非零状态退出时保留其退出码
+func commandError(step string, err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return &stepError{code: exitCode(exitErr), step: step, err: err}
+	}
+	return &stepError{code: exitCommand, step: step, err: err}
+}
+
+// exitStatus 以指定的退出码退出，不输出错误信息，用于子进程已结束的情况
+func exitStatus(code int) error {
+	return &stepError{code: code}
+}
+
+// errorExitCode 返回错误对应的退出码
+func errorExitCode(err error) int {
+	var stepErr *stepError
+	if errors.As(err, &stepErr) {
+		return stepErr.code
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitCode(exitErr)
+	}
+	return exitGeneral
+}
+
+// exitWithError 输出错误并以对应的退出码退出，只在命令入口调用，确保此前的清理逻辑都已执行，未指定步骤的错误已在出错时输出
+func exitWithError(err error) {
+	if err == nil {
+		return
+	}
+	var stepErr *stepError
+	if !errors.As(err, &stepErr) || stepErr.step != "" {
+		errorPrint("%s %v\n", outputFlag, err)
+	}
+	os.Exit(errorExitCode(err))
+}
@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
 	"strings"
@@ -32,16 +31,18 @@ func parse(v string, r interface{}) error {
 	return json.Unmarshal([]byte(v), r)
 }
 
-func fetchMeta(url string) (result []meta) {
+func fetchMeta(url string) ([]meta, error) {
 	resp, err := http.Get(url)
 	if err != nil {
-		log.Println("fetching meta:", err)
-		return
+		return nil, fmt.Errorf("fetching meta: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching meta: %s", resp.Status)
 	}
 	b, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Println("reading body:", err)
-		return
+		return nil, fmt.Errorf("reading body: %w", err)
 	}
 	ret := struct {
 		Code int
@@ -49,8 +50,7 @@ func fetchMeta(url string) (result []meta) {
 		Data []meta
 	}{}
 	if err := parse(string(b), &ret); err != nil {
-		log.Println("parsing body:", err)
-		return
+		return nil, fmt.Errorf("parsing body: %w", err)
 	}
 	for i, meta := range ret.Data {
 		for j, field := range meta.Fields {
@@ -67,7 +67,7 @@ func fetchMeta(url string) (result []meta) {
 		ret.Data[i] = meta
 	}
 
-	return ret.Data
+	return ret.Data, nil
 }
 
 func tableTmpl() string {
@@ -116,8 +116,11 @@ export default class {{.Name}}Table extends React.Component {
 }`
 }
 
-func fanoTable(metaURL string, outDir string) {
-	list := fetchMeta(metaURL)
+func fanoTable(metaURL string, outDir string) error {
+	list, err := fetchMeta(metaURL)
+	if err != nil {
+		return err
+	}
 	tmpl := tableTmpl()
 	t := template.Must(template.New("table").Funcs(template.FuncMap{
 		"notLastField": func(index int, length int) bool {
@@ -125,14 +128,21 @@ func fanoTable(metaURL string, outDir string) {
 		},
 		"toLower": strings.ToLower,
 	}).Parse(tmpl))
-	ensureDir(outDir)
+	if err := ensureDir(outDir); err != nil {
+		return fmt.Errorf("create %s: %w", outDir, err)
+	}
 	for _, meta := range list {
 		f, err := os.Create(fmt.Sprintf("%s/%s.jsx", outDir, meta.Name))
+		if err != nil {
+			return err
+		}
 		if err = t.Execute(f, meta); err != nil {
-			log.Println("executing template:", err)
+			f.Close()
+			return fmt.Errorf("executing template: %w", err)
 		}
 		if err := f.Close(); err != nil {
-			log.Println("out to file:", err)
+			return fmt.Errorf("out to file: %w", err)
 		}
 	}
+	return nil
 }
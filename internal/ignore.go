@@ -0,0 +1,185 @@
+package internal
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const ignoreFile = ".shinoignore"
+
+var (
+	// 默认忽略的目录，以及根目录中shino自身的配置文件
+	defaultIgnores = []string{".shino/", "node_modules/", ".git/", ".idea/", ".vscode/", ".history/", "/" + configFile, "/" + lockFile, "/" + ignoreFile}
+	ignore         = newIgnoreMatcher(defaultIgnores)
+)
+
+type (
+	// ignoreRule 单条gitignore规则
+	ignoreRule struct {
+		pattern *regexp.Regexp
+		negate  bool
+		dirOnly bool
+	}
+	// ignoreMatcher 按gitignore语法匹配相对路径，后面的规则优先
+	ignoreMatcher struct {
+		rules []ignoreRule
+	}
+)
+
+func newIgnoreMatcher(patterns []string) *ignoreMatcher {
+	m := &ignoreMatcher{}
+	m.add(patterns)
+	return m
+}
+
+func (m *ignoreMatcher) add(patterns []string) {
+	for _, p := range patterns {
+		if rule, ok := compileIgnoreRule(p); ok {
+			m.rules = append(m.rules, rule)
+		}
+	}
+}
+
+// match 判断相对路径是否被忽略，父目录被忽略时其中的文件同样被忽略
+func (m *ignoreMatcher) match(rel string, isDir bool) bool {
+	rel = strings.Trim(filepath.ToSlash(rel), "/")
+	if rel == "" || rel == "." {
+		return false
+	}
+	for i := 0; i < len(rel); i++ {
+		if rel[i] == '/' && m.matchPath(rel[:i], true) {
+			return true
+		}
+	}
+	return m.matchPath(rel, isDir)
+}
+
+func (m *ignoreMatcher) matchPath(rel string, isDir bool) bool {
+	ignored := false
+	for _, rule := range m.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.pattern.MatchString(rel) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// compileIgnoreRule 将gitignore规则转换为正则表达式
+func compileIgnoreRule(line string) (rule ignoreRule, ok bool) {
+	line = strings.TrimRight(line, "\r")
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule, false
+	}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	// 包含“/”的规则相对于根目录匹配，否则匹配任意层级
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	if line == "" {
+		return rule, false
+	}
+
+	var b strings.Builder
+	b.WriteString("^")
+	if !anchored {
+		b.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '*' && i+1 < len(line) && line[i+1] == '*':
+			next := i + 2
+			atSegmentStart := i == 0 || line[i-1] == '/'
+			switch {
+			case atSegmentStart && next < len(line) && line[next] == '/':
+				// “**/”匹配零或多级目录
+				b.WriteString("(?:.*/)?")
+				i = next
+			case atSegmentStart && next == len(line):
+				// 末尾的“/**”匹配目录中的所有内容
+				b.WriteString(".*")
+				i = next - 1
+			default:
+				b.WriteString("[^/]*")
+				i = next - 1
+			}
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(line[i+1:], ']')
+			if end == 0 {
+				if next := strings.IndexByte(line[i+2:], ']'); next >= 0 {
+					end = next + 1
+				} else {
+					end = -1
+				}
+			}
+			if end < 0 {
+				b.WriteString(regexp.QuoteMeta("["))
+				continue
+			}
+			class := line[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.Replace(class, "\\", "\\\\", -1) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(line):
+			b.WriteString(regexp.QuoteMeta(string(line[i+1])))
+			i++
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return rule, false
+	}
+	rule.pattern = re
+	return rule, true
+}
+
+// loadIgnore 合并默认规则、kuu.json中的ignore及.shinoignore文件
+func loadIgnore(patterns []string) *ignoreMatcher {
+	m := newIgnoreMatcher(defaultIgnores)
+	m.add(patterns)
+	if f, err := os.Open(ignoreFile); err == nil {
+		defer f.Close()
+		var lines []string
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		m.add(lines)
+	}
+	return m
+}
+
+// isIgnored 判断root目录中的path是否被忽略
+func isIgnored(root, path string, isDir bool) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	return ignore.match(rel, isDir)
+}
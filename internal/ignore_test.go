@@ -0,0 +1,71 @@
+package internal
+
+import "testing"
+
+func TestIgnoreMatcher(t *testing.T) {
+	cases := []struct {
+		name     string
+		patterns []string
+		path     string
+		isDir    bool
+		want     bool
+	}{
+		{"basename", []string{"*.log"}, "logs/debug.log", false, true},
+		{"basename no match", []string{"*.log"}, "debug.txt", false, false},
+		{"negate", []string{"*.log", "!keep.log"}, "keep.log", false, false},
+		{"negate other", []string{"*.log", "!keep.log"}, "drop.log", false, true},
+		{"negate before rule", []string{"!keep.log", "*.log"}, "keep.log", false, true},
+		{"anchored root", []string{"/build"}, "build", true, true},
+		{"anchored nested", []string{"/build"}, "sub/build", true, false},
+		{"unanchored nested", []string{"build"}, "sub/build", true, true},
+		{"path with slash is anchored", []string{"sub/build"}, "a/sub/build", true, false},
+		{"leading double star", []string{"**/cache"}, "a/b/cache", true, true},
+		{"leading double star at root", []string{"**/cache"}, "cache", true, true},
+		{"middle double star", []string{"a/**/b"}, "a/x/y/b", false, true},
+		{"middle double star zero dirs", []string{"a/**/b"}, "a/b", false, true},
+		{"trailing double star", []string{"dist/**"}, "dist/js/app.js", false, true},
+		{"trailing double star dir itself", []string{"dist/**"}, "dist", true, false},
+		{"single star stays in segment", []string{"a/*.js"}, "a/b/c.js", false, false},
+		{"dir only matches dir", []string{"tmp/"}, "tmp", true, true},
+		{"dir only skips file", []string{"tmp/"}, "tmp", false, false},
+		{"dir only ignores children", []string{"tmp/"}, "tmp/a.txt", false, true},
+		{"question mark", []string{"?.txt"}, "a.txt", false, true},
+		{"char class", []string{"[ab].txt"}, "c.txt", false, false},
+		{"negated char class", []string{"[!ab].txt"}, "c.txt", false, true},
+		{"escaped bang", []string{`\!important`}, "!important", false, true},
+		{"comment", []string{"# comment"}, "# comment", false, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := newIgnoreMatcher(c.patterns)
+			if got := m.match(c.path, c.isDir); got != c.want {
+				t.Errorf("match(%q, %v) with %q = %v, want %v", c.path, c.isDir, c.patterns, got, c.want)
+			}
+		})
+	}
+}
+
+func TestCompileIgnoreRule(t *testing.T) {
+	cases := []struct {
+		line    string
+		ok      bool
+		negate  bool
+		dirOnly bool
+	}{
+		{"", false, false, false},
+		{"# comment", false, false, false},
+		{"/", false, false, false},
+		{"*.log   ", true, false, false},
+		{"!keep.log", true, true, false},
+		{"build/", true, false, true},
+		{"!build/", true, true, true},
+		{`\#file`, true, false, false},
+	}
+	for _, c := range cases {
+		rule, ok := compileIgnoreRule(c.line)
+		if ok != c.ok || ok && (rule.negate != c.negate || rule.dirOnly != c.dirOnly) {
+			t.Errorf("compileIgnoreRule(%q) = {negate: %v, dirOnly: %v}, %v, want {negate: %v, dirOnly: %v}, %v",
+				c.line, rule.negate, rule.dirOnly, ok, c.negate, c.dirOnly, c.ok)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+var (
+	// 影响依赖安装结果的文件
+	depsFiles = []string{"package.json", "package-lock.json", "npm-shrinkwrap.json", "yarn.lock", "pnpm-lock.yaml"}
+	// 上次成功安装时依赖文件的哈希
+	workInstallSumFile = path.Join(workDir, "install.sum")
+)
+
+// depsHash 计算合并目录中依赖文件的哈希
+func depsHash() (string, error) {
+	h := sha256.New()
+	for _, name := range depsFiles {
+		sum, err := hashFile(path.Join(workMergedDir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s  %s\n", sum, name)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// needInstall 依赖目录为空或依赖文件与上次安装时不一致时需要重新安装
+func needInstall() (bool, error) {
+	if installCmd == "" {
+		return false, nil
+	}
+	if isEmptyDir(path.Join(workMergedDir, "node_modules")) {
+		return true, nil
+	}
+	hash, err := depsHash()
+	if err != nil {
+		return false, err
+	}
+	data, err := ioutil.ReadFile(workInstallSumFile)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	return strings.TrimSpace(string(data)) != hash, nil
+}
+
+// install 执行install命令并记录依赖文件的哈希
+func install(ctx context.Context) error {
+	cmd, err := mergedCmd(installCmd)
+	if err != nil {
+		return err
+	}
+	if err := runContext(ctx, cmd); err != nil {
+		return err
+	}
+	hash, err := depsHash()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(workInstallSumFile, []byte(hash+"\n"), 0644)
+}
+
+// depsChanged 判断本次同步是否修改了依赖文件
+func depsChanged(result mergeResult) bool {
+	for _, change := range result.Changes {
+		for _, name := range depsFiles {
+			if change.Path == name {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package internal
+
+import "testing"
+
+func TestMergeJSONValue(t *testing.T) {
+	cases := []struct {
+		name  string
+		base  string
+		patch string
+		want  string
+	}{
+		{"add key", `{"a":1}`, `{"b":2}`, `{"a":1,"b":2}`},
+		{"replace value", `{"a":1,"b":2}`, `{"a":3}`, `{"a":3,"b":2}`},
+		{"null deletes key", `{"a":1,"b":2}`, `{"a":null}`, `{"b":2}`},
+		{"null deletes missing key", `{"a":1}`, `{"b":null}`, `{"a":1}`},
+		{"nested merge", `{"s":{"dev":"a","build":"b"}}`, `{"s":{"dev":"c"}}`, `{"s":{"dev":"c","build":"b"}}`},
+		{"nested null", `{"s":{"dev":"a","build":"b"}}`, `{"s":{"build":null}}`, `{"s":{"dev":"a"}}`},
+		{"array replaced", `{"a":[1,2]}`, `{"a":[3]}`, `{"a":[3]}`},
+		{"object replaces scalar", `{"a":1}`, `{"a":{"b":null,"c":2}}`, `{"a":{"c":2}}`},
+		{"scalar patch", `{"a":1}`, `"x"`, `"x"`},
+		{"key order kept", `{"b":1,"a":2}`, `{"c":3,"b":4}`, `{"b":4,"a":2,"c":3}`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			base, err := decodeJSON([]byte(c.base))
+			if err != nil {
+				t.Fatal(err)
+			}
+			patch, err := decodeJSON([]byte(c.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := marshalJSON(mergeJSONValue(base, patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != c.want {
+				t.Errorf("merge %s with %s = %s, want %s", c.base, c.patch, data, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	// kuu.json中配置的中间层，按优先级从低到高排列
+	layers []string
+	// kuu.json中配置的挂载路径，键为中间层及sync目录中的路径，值为合并目录中的路径
+	mounts        map[string]string
+	workLayersDir = path.Join(workDir, "layers")
+
+	layerNamePattern = regexp.MustCompile(`[^0-9A-Za-z._-]+`)
+)
+
+// layerRefKey 中间层仓库中记录所配置ref的git配置项
+const layerRefKey = "shino.ref"
+
+// overlay 位于base与sync目录之间的中间层，可以是git仓库或本地目录
+type overlay struct {
+	URL   string
+	Ref   string
+	Dir   string
+	Local bool
+}
+
+// parseOverlays 解析中间层配置，git仓库可以通过“#ref”指定分支、标签或commit
+func parseOverlays(entries []string) []overlay {
+	var list []overlay
+	for i, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !isGitURL(entry) {
+			list = append(list, overlay{Dir: entry, Local: true})
+			continue
+		}
+		o := overlay{URL: entry}
+		if idx := strings.LastIndex(entry, "#"); idx > 0 {
+			o.URL, o.Ref = entry[:idx], entry[idx+1:]
+		}
+		name := strings.TrimSuffix(path.Base(o.URL), ".git")
+		o.Dir = path.Join(workLayersDir, fmt.Sprintf("%d-%s", i, layerNamePattern.ReplaceAllString(name, "_")))
+		list = append(list, o)
+	}
+	return list
+}
+
+func isGitURL(s string) bool {
+	return strings.Contains(s, "://") || strings.HasPrefix(s, "git@") || strings.HasSuffix(strings.SplitN(s, "#", 2)[0], ".git")
+}
+
+// prepareOverlays 克隆git中间层，远程地址变化时重新克隆，ref变化时检出配置的版本，update为true时拉取远程更新
+func prepareOverlays(update bool) error {
+	for _, o := range parseOverlays(layers) {
+		if o.Local {
+			continue
+		}
+		if !isEmptyDir(o.Dir) && remoteURL(o.Dir) != o.URL {
+			logInfo(logFields{"layer": o.Dir}, "%s %s is not a clone of %s, cloning again\n", outputFlag, o.Dir, o.URL)
+			if err := os.RemoveAll(o.Dir); err != nil {
+				return err
+			}
+		}
+		refChanged := layerRef(o.Dir) != o.Ref
+		switch {
+		case isEmptyDir(o.Dir):
+			if err := ensureDir(o.Dir); err != nil {
+				return err
+			}
+			if err := clone(o.URL, o.Ref, o.Dir); err != nil {
+				return err
+			}
+		case update || refChanged:
+			if err := updateRef(o.Dir, o.URL, o.Ref, refChanged); err != nil {
+				return err
+			}
+		default:
+			continue
+		}
+		// 记录检出时配置的ref，用于判断配置是否发生变化
+		if err := runCmd(gitCmd(o.Dir, "config", layerRefKey, o.Ref)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remoteURL 获取本地仓库的远程地址，不是git仓库时返回空字符串
+func remoteURL(local string) string {
+	out, err := gitCmd(local, "remote", "get-url", "origin").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// layerRef 获取中间层仓库上次检出时配置的ref
+func layerRef(local string) string {
+	out, _ := gitCmd(local, "config", "--get", layerRefKey).Output()
+	return strings.TrimSpace(string(out))
+}
+
+// localLayers 本地合并的源目录：base在最下层，其次是中间层，sync目录在最上层
+func localLayers() []mergeLayer {
+	list := []mergeLayer{{Dir: workBaseDir}}
+	absSync, _ := filepath.Abs(syncDir)
+	for _, o := range parseOverlays(layers) {
+		if abs, _ := filepath.Abs(o.Dir); abs == absSync {
+			continue
+		}
+		list = append(list, overlayLayer(o.Dir, workBaseDir))
+	}
+	return append(list, overlayLayer(syncDir, workBaseDir))
+}
+
+// watchDirs 需要监听的目录：本地中间层及sync目录
+func watchDirs() []string {
+	var dirs []string
+	absSync, _ := filepath.Abs(syncDir)
+	for _, o := range parseOverlays(layers) {
+		if abs, _ := filepath.Abs(o.Dir); o.Local && abs != absSync {
+			dirs = append(dirs, o.Dir)
+		}
+	}
+	return append(dirs, syncDir)
+}
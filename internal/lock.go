@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+)
+
+const lockFile = "kuu.lock"
+
+// Lock 基础项目的锁定信息
+type Lock struct {
+	Base     string    `json:"base"`
+	Ref      string    `json:"ref,omitempty"`
+	Sha      string    `json:"sha"`
+	ClonedAt time.Time `json:"clonedAt"`
+	Hash     string    `json:"hash"`
+	// Overrides 记录审阅覆盖文件时base文件的哈希
+	Overrides map[string]string `json:"overrides,omitempty"`
+}
+
+// readLock 读取锁文件，文件不存在时返回nil
+func readLock(file string) (*Lock, error) {
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var lock Lock
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", file, err)
+	}
+	return &lock, nil
+}
+
+func writeLock(file string, lock *Lock) error {
+	data, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, append(data, '\n'), 0644)
+}
+
+// lockedRef 返回应当检出的版本：锁文件与当前base一致时使用锁定的commit，否则使用配置的ref
+func lockedRef(lock *Lock, base, ref string) string {
+	if lock == nil || lock.Sha == "" {
+		return ref
+	}
+	if lock.Base != base {
+		warnPrint("%s %s is locked to %s, ignored for %s, run `shino update` to refresh it\n", outputFlag, lockFile, lock.Base, base)
+		return ref
+	}
+	if lock.Ref != ref {
+		warnPrint("%s %s is locked to %s@%s, run `shino update` to apply %q\n", outputFlag, lockFile, lock.Base, lock.Sha, ref)
+	}
+	return lock.Sha
+}
+
+// newLock 根据已克隆的base目录及合并清单生成锁定信息
+func newLock(base, ref, baseDir string, files manifest) (*Lock, error) {
+	sha, err := headCommit(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	return &Lock{
+		Base:     base,
+		Ref:      ref,
+		Sha:      sha,
+		ClonedAt: time.Now().UTC().Truncate(time.Second),
+		Hash:     hashManifest(files),
+	}, nil
+}
+
+// refreshLock 生成新的锁定信息，保留已有的审阅记录，base的commit未变化时保留原来的克隆时间
+func refreshLock(old *Lock, base, ref, baseDir, manifestFile string) (*Lock, error) {
+	files, err := readManifest(manifestFile)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := newLock(base, ref, baseDir, files)
+	if err != nil {
+		return nil, err
+	}
+	if old != nil {
+		lock.Overrides = old.Overrides
+		if old.Base == lock.Base && old.Sha == lock.Sha && !old.ClonedAt.IsZero() {
+			lock.ClonedAt = old.ClonedAt
+		}
+	}
+	if err := writeLock(lockFile, lock); err != nil {
+		return nil, err
+	}
+	successPrint("%s write %s: %s\n", outputFlag, lockFile, lock.Sha)
+	return lock, nil
+}
+
+// hashManifest 根据合并清单中的路径及内容哈希计算合并结果的哈希值，不受合并目录中构建产物及缓存的影响
+func hashManifest(files manifest) string {
+	rels := make([]string, 0, len(files))
+	for rel := range files {
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+
+	h := sha256.New()
+	for _, rel := range rels {
+		fmt.Fprintf(h, "%s  %s\n", files[rel].Hash, rel)
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// hashFile 计算单个文件内容的sha256
+func hashFile(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,114 @@
+package internal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shino")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		name    string
+		content string
+		want    *Lock
+		wantErr bool
+	}{
+		{name: "missing"},
+		{
+			name:    "valid",
+			content: `{"base":"https://example.com/base.git","ref":"v1","sha":"abc","hash":"sha256:x"}`,
+			want:    &Lock{Base: "https://example.com/base.git", Ref: "v1", Sha: "abc", Hash: "sha256:x"},
+		},
+		{
+			name:    "overrides",
+			content: `{"base":"b","sha":"abc","overrides":{"a.txt":"123"}}`,
+			want:    &Lock{Base: "b", Sha: "abc", Overrides: map[string]string{"a.txt": "123"}},
+		},
+		{name: "invalid", content: `{"base":`, wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			file := filepath.Join(dir, c.name+".lock")
+			if c.content != "" {
+				if err := ioutil.WriteFile(file, []byte(c.content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := readLock(file)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("readLock() error = %v, wantErr %v", err, c.wantErr)
+			}
+			switch {
+			case c.want == nil && got != nil:
+				t.Errorf("readLock() = %+v, want nil", got)
+			case c.want != nil && (got == nil || got.Base != c.want.Base || got.Ref != c.want.Ref || got.Sha != c.want.Sha ||
+				got.Hash != c.want.Hash || len(got.Overrides) != len(c.want.Overrides)):
+				t.Errorf("readLock() = %+v, want %+v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestLockedRef(t *testing.T) {
+	cases := []struct {
+		name string
+		lock *Lock
+		base string
+		ref  string
+		want string
+	}{
+		{"no lock", nil, "b", "master", "master"},
+		{"no sha", &Lock{Base: "b"}, "b", "master", "master"},
+		{"locked", &Lock{Base: "b", Ref: "master", Sha: "abc"}, "b", "master", "abc"},
+		{"locked default ref", &Lock{Base: "b", Sha: "abc"}, "b", "", "abc"},
+		{"ref changed keeps sha", &Lock{Base: "b", Ref: "master", Sha: "abc"}, "b", "v1", "abc"},
+		{"base changed", &Lock{Base: "a", Ref: "master", Sha: "abc"}, "b", "master", "master"},
+	}
+	for _, c := range cases {
+		if got := lockedRef(c.lock, c.base, c.ref); got != c.want {
+			t.Errorf("%s: lockedRef() = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestHashManifest(t *testing.T) {
+	a := manifest{
+		"a.txt":     {Source: "/sync/a.txt", Hash: "1"},
+		"src/b.txt": {Source: "/base/src/b.txt", Hash: "2"},
+	}
+	cases := []struct {
+		name  string
+		files manifest
+		same  bool
+	}{
+		{"same content from other sources", manifest{
+			"src/b.txt": {Source: "/layer/src/b.txt", Hash: "2"},
+			"a.txt":     {Source: "/base/a.txt", Hash: "1"},
+		}, true},
+		{"content changed", manifest{
+			"a.txt":     {Hash: "1"},
+			"src/b.txt": {Hash: "3"},
+		}, false},
+		{"file renamed", manifest{
+			"a.txt":     {Hash: "1"},
+			"src/c.txt": {Hash: "2"},
+		}, false},
+		{"file removed", manifest{"a.txt": {Hash: "1"}}, false},
+	}
+	want := hashManifest(a)
+	if got := hashManifest(a); got != want {
+		t.Fatalf("hashManifest() is not stable: %s != %s", got, want)
+	}
+	for _, c := range cases {
+		if got := hashManifest(c.files); (got == want) != c.same {
+			t.Errorf("%s: hashManifest() = %s, base %s, want same %v", c.name, got, want, c.same)
+		}
+	}
+}
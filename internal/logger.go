@@ -0,0 +1,232 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/fatih/color"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// 日志级别
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var (
+	// 日志格式：text或json
+	logFormat = logFormatText
+	// 低于该级别的日志不输出
+	minLogLevel = levelInfo
+
+	levelNames  = []string{"debug", "info", "warn", "error"}
+	levelColors = []color.Attribute{color.FgHiBlack, color.FgHiGreen, color.FgHiYellow, color.FgHiRed}
+)
+
+type (
+	logLevel int
+	// logFields 日志附带的字段
+	logFields map[string]interface{}
+)
+
+func (l logLevel) String() string {
+	return levelNames[l]
+}
+
+// parseLogLevel 解析日志级别名称
+func parseLogLevel(name string) (logLevel, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(name, n) {
+			return logLevel(i), nil
+		}
+	}
+	return levelInfo, fmt.Errorf("unknown log level %q, expected one of %s", name, strings.Join(levelNames, ", "))
+}
+
+// setLogLevel 设置日志级别，verbose相当于debug，quiet相当于warn，level优先
+func setLogLevel(level string, verbose, quiet bool) error {
+	switch {
+	case verbose && quiet:
+		return configError("parse log level", fmt.Errorf("--verbose and --quiet cannot be used together"))
+	case level != "":
+		l, err := parseLogLevel(level)
+		if err != nil {
+			return configError("parse log level", err)
+		}
+		minLogLevel = l
+	case verbose:
+		minLogLevel = levelDebug
+	case quiet:
+		minLogLevel = levelWarn
+	}
+	return nil
+}
+
+// setLogFormat 设置日志格式，noColor为true或设置了NO_COLOR环境变量时不输出颜色
+func setLogFormat(format string, noColor bool) error {
+	switch format {
+	case "", logFormatText:
+		logFormat = logFormatText
+	case logFormatJSON:
+		logFormat = logFormatJSON
+	default:
+		return configError("parse log format", fmt.Errorf("unknown log format %q, expected text or json", format))
+	}
+	// 非终端输出时color包会自动关闭颜色
+	if noColor || os.Getenv("NO_COLOR") != "" || logFormat == logFormatJSON {
+		color.NoColor = true
+	}
+	return nil
+}
+
+// logWith 按日志格式输出一条日志，文本格式下format中的“[SHINO]”前缀会保留，JSON格式下会去掉
+func logWith(level logLevel, fields logFields, format string, a ...interface{}) {
+	if level < minLogLevel {
+		return
+	}
+	writeLog(level, fields, fmt.Sprintf(format, a...))
+}
+
+// logDebug 输出调试日志
+func logDebug(fields logFields, format string, a ...interface{}) {
+	logWith(levelDebug, fields, format, a...)
+}
+
+// writeLog 输出日志，不受日志级别限制
+func writeLog(level logLevel, fields logFields, msg string) {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	if logFormat != logFormatJSON {
+		if _, err := color.New(levelColors[level], color.Bold).Print(msg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return
+	}
+	msg = strings.TrimSuffix(msg, "\n")
+	if strings.HasPrefix(msg, outputFlag) {
+		msg = strings.TrimSpace(msg[len(outputFlag):])
+	}
+	fmt.Fprintf(os.Stdout, "%s\n", jsonEntry(level, msg, fields))
+}
+
+// jsonEntry 生成一行JSON日志，time、level、msg在前，其他字段按名称排序
+func jsonEntry(level logLevel, msg string, fields logFields) []byte {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, `{"time":%q,"level":%q,"msg":`, time.Now().Format(time.RFC3339Nano), level)
+	writeJSON(buf, msg)
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		buf.WriteByte(',')
+		writeJSON(buf, k)
+		buf.WriteByte(':')
+		writeJSON(buf, fields[k])
+	}
+	buf.WriteByte('}')
+	return buf.Bytes()
+}
+
+func writeJSON(buf *bytes.Buffer, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(err.Error())
+	}
+	buf.Write(data)
+}
+
+// logInfo 输出带字段的普通日志
+func logInfo(fields logFields, format string, a ...interface{}) {
+	logWith(levelInfo, fields, format, a...)
+}
+
+// warnPrint 输出警告
+func warnPrint(format string, a ...interface{}) {
+	logWith(levelWarn, nil, format, a...)
+}
+
+// flusher 输出缓冲中剩余内容的Writer
+type flusher interface {
+	Flush() error
+}
+
+// flushOutput 子进程结束后输出其最后不完整的一行
+func flushOutput(cmd *exec.Cmd) {
+	for _, w := range []io.Writer{cmd.Stdout, cmd.Stderr} {
+		if f, ok := w.(flusher); ok {
+			_ = f.Flush()
+		}
+	}
+}
+
+// resultFields 合并结果对应的日志字段
+func resultFields(r mergeResult) logFields {
+	return logFields{"added": r.Added, "updated": r.Updated, "removed": r.Removed, "unchanged": r.Unchanged}
+}
+
+// lineWriter 将输出按行拆分后交给writeLine处理，不包含换行符
+type lineWriter struct {
+	writeLine func(line []byte) error
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+func newLineWriter(writeLine func(line []byte) error) *lineWriter {
+	return &lineWriter{writeLine: writeLine}
+}
+
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	lw.buf = append(lw.buf, p...)
+	for {
+		idx := bytes.IndexByte(lw.buf, '\n')
+		if idx < 0 {
+			break
+		}
+		line := lw.buf[:idx]
+		lw.buf = lw.buf[idx+1:]
+		if err := lw.writeLine(line); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush 输出缓冲中不完整的最后一行
+func (lw *lineWriter) Flush() error {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	if len(lw.buf) == 0 {
+		return nil
+	}
+	line := lw.buf
+	lw.buf = nil
+	return lw.writeLine(line)
+}
+
+// newLineLogger 将子进程的输出按行输出为日志
+func newLineLogger(fields logFields) *lineWriter {
+	return newLineWriter(func(line []byte) error {
+		// 子进程的输出不受日志级别限制
+		writeLog(levelInfo, fields, strings.TrimRight(string(line), "\r"))
+		return nil
+	})
+}
@@ -0,0 +1,519 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var workManifestFile = filepath.Join(workDir, "manifest.json")
+
+type (
+	// mergeLayer 参与合并的源目录，Mounts为空时按原路径合并
+	mergeLayer struct {
+		Dir    string
+		Mounts []mountRule
+	}
+	// mountRule 将源目录中的From路径挂载到目标目录中的To路径，空字符串表示根目录
+	mountRule struct {
+		From string
+		To   string
+	}
+	// fileSource 文件来源，Layer为所在源目录的序号
+	fileSource struct {
+		Layer int
+		Path  string
+	}
+	// manifestEntry 合并目录中单个文件的来源记录
+	manifestEntry struct {
+		Source  string    `json:"source"`
+		Size    int64     `json:"size"`
+		ModTime time.Time `json:"modTime"`
+		Hash    string    `json:"hash"`
+		// Stamp 由多个来源合并生成的文件记录所有来源的标识
+		Stamp string `json:"stamp,omitempty"`
+	}
+	// manifest 以合并目录中的相对路径为键
+	manifest map[string]*manifestEntry
+	// mergeChange 合并目录中单个文件的变更
+	mergeChange struct {
+		Op     string
+		Path   string
+		Source string
+	}
+	// mergeResult 合并结果统计
+	mergeResult struct {
+		Added     int
+		Updated   int
+		Removed   int
+		Unchanged int
+		Changes   []mergeChange
+	}
+	// merger 按顺序将多个目录合并到目标目录，后面的目录覆盖前面的
+	merger struct {
+		layers   []mergeLayer
+		dest     string
+		manifest manifest
+	}
+)
+
+func (r mergeResult) String() string {
+	return fmt.Sprintf("%d added, %d updated, %d removed, %d unchanged", r.Added, r.Updated, r.Removed, r.Unchanged)
+}
+
+const (
+	mergeAdd     = "add"
+	mergeUpdate  = "update"
+	mergeRestore = "restore"
+	mergeRemove  = "remove"
+)
+
+func (r *mergeResult) add(other mergeResult) {
+	r.Added += other.Added
+	r.Updated += other.Updated
+	r.Removed += other.Removed
+	r.Unchanged += other.Unchanged
+	r.Changes = append(r.Changes, other.Changes...)
+}
+
+func (r *mergeResult) record(op, rel, src string) {
+	switch op {
+	case mergeAdd:
+		r.Added++
+	case mergeUpdate, mergeRestore:
+		r.Updated++
+	case mergeRemove:
+		r.Removed++
+	}
+	r.Changes = append(r.Changes, mergeChange{Op: op, Path: rel, Source: src})
+}
+
+// readManifest 读取合并清单，清单损坏时按空清单处理，重新完整合并
+func readManifest(file string) (manifest, error) {
+	m := make(manifest)
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		warnPrint("%s parse %s: %v, merge all files again\n", outputFlag, file, err)
+		return make(manifest), nil
+	}
+	return m, nil
+}
+
+// writeManifest 写入合并清单，先写入临时文件再重命名，避免中途退出时留下不完整的清单
+func writeManifest(file string, m manifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = writeFileAtomic(file, bytes.NewReader(data), 0644, time.Time{})
+	return err
+}
+
+// sources 收集目标目录中每个文件的所有来源，按目录顺序排列
+func (m *merger) sources() (map[string][]fileSource, error) {
+	files := make(map[string][]fileSource)
+	for i, layer := range m.layers {
+		err := layer.walk(layer.Dir, func(rel, path string) {
+			files[rel] = append(files[rel], fileSource{Layer: i, Path: path})
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// walk 遍历源目录中root下的文件，fn接收文件在目标目录中的相对路径
+func (l mergeLayer) walk(root string, fn func(rel, path string)) error {
+	if root != l.Dir && l.ignored(root, true) {
+		return nil
+	}
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if l.ignored(path, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if rel, ok := l.destRel(path); ok {
+			fn(rel, path)
+		}
+		return nil
+	})
+}
+
+// ignored 判断源目录中的路径是否被忽略
+func (l mergeLayer) ignored(path string, isDir bool) bool {
+	return isIgnored(l.Dir, path, isDir)
+}
+
+// destRel 源文件在目标目录中的相对路径
+func (l mergeLayer) destRel(path string) (string, bool) {
+	rel, err := filepath.Rel(l.Dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == "." {
+		rel = ""
+	}
+	for _, rule := range l.rules() {
+		if sub, ok := trimPathPrefix(rel, rule.From); ok {
+			return pathpkg.Join(rule.To, sub), true
+		}
+	}
+	return "", false
+}
+
+// sourcePaths 目标目录中的相对路径在源目录中可能对应的路径
+func (l mergeLayer) sourcePaths(rel string) []string {
+	var list []string
+	for _, rule := range l.rules() {
+		sub, ok := trimPathPrefix(rel, rule.To)
+		if !ok {
+			continue
+		}
+		src := filepath.Join(l.Dir, filepath.FromSlash(pathpkg.Join(rule.From, sub)))
+		// 只保留正向映射一致的路径，避免被更具体的规则覆盖
+		if dest, ok := l.destRel(src); ok && dest == rel {
+			list = append(list, src)
+		}
+	}
+	return list
+}
+
+func (l mergeLayer) rules() []mountRule {
+	if len(l.Mounts) == 0 {
+		return []mountRule{{}}
+	}
+	return l.Mounts
+}
+
+func trimPathPrefix(rel, prefix string) (string, bool) {
+	switch {
+	case prefix == "":
+		return rel, true
+	case rel == prefix:
+		return "", true
+	case strings.HasPrefix(rel, prefix+"/"):
+		return rel[len(prefix)+1:], true
+	}
+	return "", false
+}
+
+// mountRules 将kuu.json中的mounts转换为挂载规则，更具体的路径优先，未匹配的路径按原路径合并
+func mountRules(mounts map[string]string) []mountRule {
+	var rules []mountRule
+	hasRoot := false
+	for from, to := range mounts {
+		rule := mountRule{From: cleanRel(from), To: cleanRel(to)}
+		if rule.From == "" {
+			hasRoot = true
+		}
+		rules = append(rules, rule)
+	}
+	if !hasRoot {
+		rules = append(rules, mountRule{})
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if len(rules[i].From) != len(rules[j].From) {
+			return len(rules[i].From) > len(rules[j].From)
+		}
+		return rules[i].From < rules[j].From
+	})
+	return rules
+}
+
+func cleanRel(p string) string {
+	return strings.Trim(pathpkg.Clean("/"+filepath.ToSlash(strings.TrimSpace(p))), "/")
+}
+
+// overlayLayer 叠加在base之上的源目录，未配置mounts时沿用src目录的判断规则
+func overlayLayer(dir, baseDir string) mergeLayer {
+	if len(mounts) > 0 {
+		return mergeLayer{Dir: dir, Mounts: mountRules(mounts)}
+	}
+	if destSrcCase(dir, baseDir) != baseDir {
+		return mergeLayer{Dir: dir, Mounts: []mountRule{{To: "src"}}}
+	}
+	return mergeLayer{Dir: dir}
+}
+
+// plan 计算目标目录中每个文件的来源，按优先级从低到高排列
+func (m *merger) plan() (map[string][]string, error) {
+	all, err := m.sources()
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string][]string, len(all))
+	for rel, srcs := range all {
+		for _, src := range srcs {
+			files[rel] = append(files[rel], src.Path)
+		}
+	}
+	return files, nil
+}
+
+// merge 只复制内容有变化的文件，并删除已从所有源目录中消失的文件
+func (m *merger) merge() (result mergeResult, err error) {
+	files, err := m.plan()
+	if err != nil {
+		return result, err
+	}
+	rels := make([]string, 0, len(files))
+	for rel := range files {
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+
+	for _, rel := range rels {
+		srcs := files[rel]
+		changed, existed, err := m.mergeFile(rel, srcs)
+		if err != nil {
+			return result, err
+		}
+		switch {
+		case !changed:
+			result.Unchanged++
+		case existed:
+			result.record(mergeUpdate, rel, srcs[len(srcs)-1])
+		default:
+			result.record(mergeAdd, rel, srcs[len(srcs)-1])
+		}
+	}
+	for rel := range m.manifest {
+		if _, ok := files[rel]; ok {
+			continue
+		}
+		if err := m.removeFile(rel); err != nil {
+			return result, err
+		}
+		result.record(mergeRemove, rel, "")
+	}
+	return result, nil
+}
+
+// resolve 返回相对路径在各源目录中对应的文件，按优先级从低到高排列
+func (m *merger) resolve(rel string) []string {
+	var srcs []string
+	for i, layer := range m.layers {
+		for _, src := range layer.sourcePaths(rel) {
+			if info, err := os.Stat(src); err == nil && !info.IsDir() && !m.layers[i].ignored(src, false) {
+				srcs = append(srcs, src)
+				break
+			}
+		}
+	}
+	return srcs
+}
+
+// update 重新合并单个文件或目录：覆盖文件被删除时恢复下层目录中的版本，所有目录中都不存在时才删除
+func (m *merger) update(rel string) (result mergeResult, err error) {
+	rels := map[string]bool{rel: true}
+	for r := range m.manifest {
+		if strings.HasPrefix(r, rel+"/") {
+			rels[r] = true
+		}
+	}
+	for _, layer := range m.layers {
+		for _, src := range layer.sourcePaths(rel) {
+			if info, err := os.Stat(src); err == nil && info.IsDir() {
+				err := layer.walk(src, func(r, _ string) {
+					rels[r] = true
+				})
+				if err != nil {
+					return result, err
+				}
+			}
+		}
+	}
+	sorted := make([]string, 0, len(rels))
+	for r := range rels {
+		sorted = append(sorted, r)
+	}
+	sort.Strings(sorted)
+
+	for _, r := range sorted {
+		srcs := m.resolve(r)
+		if len(srcs) == 0 {
+			destPath := filepath.Join(m.dest, filepath.FromSlash(r))
+			_, tracked := m.manifest[r]
+			if info, err := os.Stat(destPath); tracked || (err == nil && !info.IsDir()) {
+				if err := m.removeFile(r); err != nil {
+					return result, err
+				}
+				result.record(mergeRemove, r, "")
+			}
+			continue
+		}
+		src := srcs[len(srcs)-1]
+		op := mergeUpdate
+		if entry := m.manifest[r]; entry != nil && entry.Source != src {
+			if _, err := os.Stat(entry.Source); os.IsNotExist(err) {
+				op = mergeRestore
+			}
+		}
+		changed, existed, err := m.mergeFile(r, srcs)
+		if err != nil {
+			return result, err
+		}
+		switch {
+		case !changed:
+			result.Unchanged++
+		case !existed:
+			result.record(mergeAdd, r, src)
+		default:
+			result.record(op, r, src)
+		}
+	}
+	return result, nil
+}
+
+// mergeFile 合并单个文件，srcs为各源目录中的同名文件，按优先级从低到高排列
+func (m *merger) mergeFile(rel string, srcs []string) (changed, existed bool, err error) {
+	fn := mergeStrategy(rel)
+	if fn == nil || len(srcs) < 2 {
+		return m.copySource(rel, srcs[len(srcs)-1])
+	}
+	last := srcs[len(srcs)-1]
+	lastInfo, err := os.Stat(last)
+	if err != nil {
+		return false, false, err
+	}
+	destPath := filepath.Join(m.dest, filepath.FromSlash(rel))
+	destInfo, destErr := os.Stat(destPath)
+	existed = destErr == nil && !destInfo.IsDir()
+
+	stamp, err := sourcesStamp(srcs)
+	if err != nil {
+		return false, existed, err
+	}
+	entry := m.manifest[rel]
+	if existed && entry != nil && entry.Stamp == stamp {
+		return false, true, nil
+	}
+	data, err := fn(srcs)
+	if err != nil {
+		warnPrint("%s merge %s: %v, use %s instead\n", outputFlag, rel, err, last)
+		return m.copySource(rel, last)
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	unchanged := false
+	if existed {
+		if entry != nil {
+			unchanged = entry.Hash == hash
+		} else if destHash, err := hashFile(destPath); err == nil {
+			unchanged = destHash == hash
+		}
+	}
+	if !unchanged {
+		var mtime time.Time
+		if preserveMtime {
+			mtime = lastInfo.ModTime()
+		}
+		if _, err := writeFileAtomic(destPath, bytes.NewReader(data), lastInfo.Mode().Perm(), mtime); err != nil {
+			return false, existed, err
+		}
+	}
+	m.manifest[rel] = &manifestEntry{
+		Source:  last,
+		Size:    lastInfo.Size(),
+		ModTime: lastInfo.ModTime(),
+		Hash:    hash,
+		Stamp:   stamp,
+	}
+	return !unchanged, existed, nil
+}
+
+// sourcesStamp 根据所有来源文件的路径、大小及修改时间生成标识，用于判断合并结果是否需要更新
+func sourcesStamp(srcs []string) (string, error) {
+	h := sha256.New()
+	for _, src := range srcs {
+		info, err := os.Stat(src)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00%d\n", src, info.Size(), info.ModTime().UnixNano())
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// copySource 根据清单判断文件是否需要复制
+func (m *merger) copySource(rel, src string) (changed, existed bool, err error) {
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return false, false, err
+	}
+	destPath := filepath.Join(m.dest, filepath.FromSlash(rel))
+	destInfo, destErr := os.Stat(destPath)
+	existed = destErr == nil && !destInfo.IsDir()
+
+	entry := m.manifest[rel]
+	if existed && entry != nil && entry.Stamp == "" && entry.Source == src && entry.Size == srcInfo.Size() && entry.ModTime.Equal(srcInfo.ModTime()) {
+		return false, true, nil
+	}
+	hash, err := hashFile(src)
+	if err != nil {
+		return false, existed, err
+	}
+	unchanged := false
+	if existed && destInfo.Size() == srcInfo.Size() {
+		if entry != nil && entry.Stamp == "" {
+			unchanged = entry.Hash == hash
+		} else if destHash, err := hashFile(destPath); err == nil {
+			unchanged = destHash == hash
+		}
+	}
+	if !unchanged {
+		if _, err := copyFile(src, destPath); err != nil {
+			return false, existed, err
+		}
+	}
+	m.manifest[rel] = &manifestEntry{
+		Source:  src,
+		Size:    srcInfo.Size(),
+		ModTime: srcInfo.ModTime(),
+		Hash:    hash,
+	}
+	return !unchanged, existed, nil
+}
+
+// removeFile 删除合并目录中的文件及其留下的空目录
+func (m *merger) removeFile(rel string) error {
+	delete(m.manifest, rel)
+	destPath := filepath.Join(m.dest, filepath.FromSlash(rel))
+	if err := os.Remove(destPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for dir := filepath.Dir(destPath); strings.HasPrefix(dir, m.dest+string(filepath.Separator)); dir = filepath.Dir(dir) {
+		if !isEmptyDir(dir) {
+			break
+		}
+		if err := os.Remove(dir); err != nil {
+			break
+		}
+	}
+	return nil
+}
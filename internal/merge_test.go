@@ -0,0 +1,452 @@
+package internal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeTree 在dir中按相对路径写入文件
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for rel, content := range files {
+		file := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readTree 读取dir中的所有文件，键为相对路径
+func readTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files[filepath.ToSlash(rel)] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "shino")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestMergerMerge(t *testing.T) {
+	cases := []struct {
+		name   string
+		layers []map[string]string
+		want   map[string]string
+		result mergeResult
+	}{
+		{
+			name:   "single layer",
+			layers: []map[string]string{{"a.txt": "a", "src/b.txt": "b"}},
+			want:   map[string]string{"a.txt": "a", "src/b.txt": "b"},
+			result: mergeResult{Added: 2},
+		},
+		{
+			name: "upper layer wins",
+			layers: []map[string]string{
+				{"a.txt": "base", "b.txt": "base"},
+				{"a.txt": "layer"},
+				{"a.txt": "sync", "c.txt": "sync"},
+			},
+			want:   map[string]string{"a.txt": "sync", "b.txt": "base", "c.txt": "sync"},
+			result: mergeResult{Added: 3},
+		},
+		{
+			name: "package.json merged",
+			layers: []map[string]string{
+				{"package.json": `{"name":"base","scripts":{"dev":"umi dev"}}`},
+				{"package.json": `{"scripts":{"lint":"eslint ."}}`},
+			},
+			want: map[string]string{"package.json": `{
+  "name": "base",
+  "scripts": {
+    "dev": "umi dev",
+    "lint": "eslint ."
+  }
+}
+`},
+			result: mergeResult{Added: 1},
+		},
+		{
+			name: "ignored dirs skipped",
+			layers: []map[string]string{
+				{"a.txt": "a", "node_modules/x/index.js": "x", ".git/HEAD": "ref"},
+			},
+			want:   map[string]string{"a.txt": "a"},
+			result: mergeResult{Added: 1},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			root := tempDir(t)
+			defer os.RemoveAll(root)
+			m := &merger{dest: filepath.Join(root, "merged"), manifest: make(manifest)}
+			for i, files := range c.layers {
+				dir := filepath.Join(root, string(rune('a'+i)))
+				writeTree(t, dir, files)
+				m.layers = append(m.layers, mergeLayer{Dir: dir})
+			}
+			result, err := m.merge()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.String() != c.result.String() {
+				t.Errorf("merge() = %v, want %v", result, c.result)
+			}
+			if got := readTree(t, m.dest); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("merged files = %q, want %q", got, c.want)
+			}
+			if len(m.manifest) != len(c.want) {
+				t.Errorf("manifest has %d entries, want %d", len(m.manifest), len(c.want))
+			}
+		})
+	}
+}
+
+func TestMergerIncremental(t *testing.T) {
+	root := tempDir(t)
+	defer os.RemoveAll(root)
+	base, sync := filepath.Join(root, "base"), filepath.Join(root, "sync")
+	writeTree(t, base, map[string]string{"a.txt": "a", "b.txt": "b", "c.txt": "c"})
+	writeTree(t, sync, map[string]string{"d.txt": "d"})
+	m := &merger{
+		layers:   []mergeLayer{{Dir: base}, {Dir: sync}},
+		dest:     filepath.Join(root, "merged"),
+		manifest: make(manifest),
+	}
+	if _, err := m.merge(); err != nil {
+		t.Fatal(err)
+	}
+	// 合并目录中的构建产物不在清单中，不会被删除
+	writeTree(t, m.dest, map[string]string{"dist/app.js": "build"})
+
+	steps := []struct {
+		name   string
+		change func()
+		want   mergeResult
+	}{
+		{"nothing changed", func() {}, mergeResult{Unchanged: 4}},
+		{"file overridden", func() {
+			writeTree(t, sync, map[string]string{"a.txt": "sync"})
+		}, mergeResult{Updated: 1, Unchanged: 3}},
+		{"file removed", func() {
+			os.Remove(filepath.Join(base, "b.txt"))
+		}, mergeResult{Removed: 1, Unchanged: 3}},
+		{"same content rewritten", func() {
+			writeTree(t, base, map[string]string{"c.txt": "c"})
+		}, mergeResult{Unchanged: 3}},
+	}
+	for _, step := range steps {
+		step.change()
+		result, err := m.merge()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.String() != step.want.String() {
+			t.Errorf("%s: merge() = %v, want %v", step.name, result, step.want)
+		}
+	}
+	want := map[string]string{"a.txt": "sync", "c.txt": "c", "d.txt": "d", "dist/app.js": "build"}
+	if got := readTree(t, m.dest); !reflect.DeepEqual(got, want) {
+		t.Errorf("merged files = %q, want %q", got, want)
+	}
+	if src := m.manifest["a.txt"].Source; src != filepath.Join(sync, "a.txt") {
+		t.Errorf("manifest source of a.txt = %s, want sync", src)
+	}
+}
+
+func TestMergerUpdate(t *testing.T) {
+	root := tempDir(t)
+	defer os.RemoveAll(root)
+	base, sync := filepath.Join(root, "base"), filepath.Join(root, "sync")
+	writeTree(t, base, map[string]string{"a.txt": "a"})
+	writeTree(t, sync, map[string]string{"dir/b.txt": "b"})
+	m := &merger{
+		layers:   []mergeLayer{{Dir: base}, {Dir: sync}},
+		dest:     filepath.Join(root, "merged"),
+		manifest: make(manifest),
+	}
+	if _, err := m.merge(); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name   string
+		change func()
+		rel    string
+		want   mergeResult
+	}{
+		{"file added", func() {
+			writeTree(t, sync, map[string]string{"c.txt": "c"})
+		}, "c.txt", mergeResult{Added: 1}},
+		{"file changed", func() {
+			writeTree(t, sync, map[string]string{"c.txt": "cc"})
+		}, "c.txt", mergeResult{Updated: 1}},
+		{"dir added", func() {
+			writeTree(t, sync, map[string]string{"new/x.txt": "x", "new/y/z.txt": "z"})
+		}, "new", mergeResult{Added: 2}},
+		{"dir removed", func() {
+			os.RemoveAll(filepath.Join(sync, "dir"))
+		}, "dir", mergeResult{Removed: 1}},
+		{"unrelated path", func() {}, "missing.txt", mergeResult{}},
+	}
+	for _, step := range steps {
+		step.change()
+		result, err := m.update(step.rel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.String() != step.want.String() {
+			t.Errorf("%s: update(%q) = %v, want %v", step.name, step.rel, result, step.want)
+		}
+	}
+	want := map[string]string{"a.txt": "a", "c.txt": "cc", "new/x.txt": "x", "new/y/z.txt": "z"}
+	if got := readTree(t, m.dest); !reflect.DeepEqual(got, want) {
+		t.Errorf("merged files = %q, want %q", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(m.dest, "dir")); !os.IsNotExist(err) {
+		t.Errorf("empty dir left in merged dir: %v", err)
+	}
+}
+
+func TestReadManifest(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	saved := manifest{"a.txt": {Source: "/base/a.txt", Size: 1, Hash: "h"}}
+	cases := []struct {
+		name    string
+		prepare func(file string)
+		want    int
+	}{
+		{"missing", func(string) {}, 0},
+		{"written", func(file string) {
+			if err := writeManifest(file, saved); err != nil {
+				t.Fatal(err)
+			}
+		}, 1},
+		{"corrupt", func(file string) {
+			if err := ioutil.WriteFile(file, []byte(`{"a.txt":{"source"`), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}, 0},
+	}
+	for _, c := range cases {
+		file := filepath.Join(dir, c.name+".json")
+		c.prepare(file)
+		m, err := readManifest(file)
+		if err != nil {
+			t.Errorf("%s: readManifest() error = %v", c.name, err)
+			continue
+		}
+		if m == nil || len(m) != c.want {
+			t.Errorf("%s: readManifest() = %v, want %d entries", c.name, m, c.want)
+		}
+	}
+}
+
+func TestMergerRestore(t *testing.T) {
+	root := tempDir(t)
+	defer os.RemoveAll(root)
+	base, sync := filepath.Join(root, "base"), filepath.Join(root, "sync")
+	writeTree(t, base, map[string]string{"a.txt": "base a", "dir/x.txt": "base x"})
+	writeTree(t, sync, map[string]string{"a.txt": "sync a", "dir/x.txt": "sync x", "dir/y.txt": "sync y"})
+	m := &merger{
+		layers:   []mergeLayer{{Dir: base}, {Dir: sync}},
+		dest:     filepath.Join(root, "merged"),
+		manifest: make(manifest),
+	}
+	if _, err := m.merge(); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name   string
+		remove string
+		rel    string
+		want   []mergeChange
+		files  map[string]string
+	}{
+		{
+			name:   "overlay file deleted",
+			remove: filepath.Join(sync, "a.txt"),
+			rel:    "a.txt",
+			want:   []mergeChange{{Op: mergeRestore, Path: "a.txt", Source: filepath.Join(base, "a.txt")}},
+			files:  map[string]string{"a.txt": "base a", "dir/x.txt": "sync x", "dir/y.txt": "sync y"},
+		},
+		{
+			name:   "overlay dir deleted",
+			remove: filepath.Join(sync, "dir"),
+			rel:    "dir",
+			want: []mergeChange{
+				{Op: mergeRestore, Path: "dir/x.txt", Source: filepath.Join(base, "dir/x.txt")},
+				{Op: mergeRemove, Path: "dir/y.txt"},
+			},
+			files: map[string]string{"a.txt": "base a", "dir/x.txt": "base x"},
+		},
+		{
+			name:   "base file deleted",
+			remove: filepath.Join(base, "a.txt"),
+			rel:    "a.txt",
+			want:   []mergeChange{{Op: mergeRemove, Path: "a.txt"}},
+			files:  map[string]string{"dir/x.txt": "base x"},
+		},
+	}
+	for _, step := range steps {
+		if err := os.RemoveAll(step.remove); err != nil {
+			t.Fatal(err)
+		}
+		result, err := m.update(step.rel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(result.Changes, step.want) {
+			t.Errorf("%s: update(%q) changes = %+v, want %+v", step.name, step.rel, result.Changes, step.want)
+		}
+		if got := readTree(t, m.dest); !reflect.DeepEqual(got, step.files) {
+			t.Errorf("%s: merged files = %q, want %q", step.name, got, step.files)
+		}
+	}
+}
+
+func TestMountMapping(t *testing.T) {
+	dir := filepath.FromSlash("/repo")
+	layer := mergeLayer{Dir: dir, Mounts: mountRules(map[string]string{"web": "src", "./web/static/": "public"})}
+	rootLayer := mergeLayer{Dir: dir, Mounts: mountRules(map[string]string{".": "src"})}
+	src := func(rel string) string {
+		return filepath.Join(dir, filepath.FromSlash(rel))
+	}
+
+	destCases := []struct {
+		layer mergeLayer
+		path  string
+		want  string
+		ok    bool
+	}{
+		{layer, src("web/a.js"), "src/a.js", true},
+		{layer, src("web/static/logo.png"), "public/logo.png", true},
+		{layer, src("web"), "src", true},
+		{layer, src("webapp/a.js"), "webapp/a.js", true},
+		{layer, src("README.md"), "README.md", true},
+		{layer, filepath.FromSlash("/other/a.js"), "", false},
+		{rootLayer, src("a.js"), "src/a.js", true},
+		{mergeLayer{Dir: dir}, src("a/b.js"), "a/b.js", true},
+	}
+	for _, c := range destCases {
+		got, ok := c.layer.destRel(c.path)
+		if got != c.want || ok != c.ok {
+			t.Errorf("destRel(%q) with %+v = %q, %v, want %q, %v", c.path, c.layer.Mounts, got, ok, c.want, c.ok)
+		}
+	}
+
+	sourceCases := []struct {
+		layer mergeLayer
+		rel   string
+		want  []string
+	}{
+		{layer, "src/a.js", []string{src("web/a.js"), src("src/a.js")}},
+		{layer, "public/logo.png", []string{src("web/static/logo.png"), src("public/logo.png")}},
+		// web/static已挂载到public，不会再映射到src/static
+		{layer, "src/static/logo.png", []string{src("src/static/logo.png")}},
+		{layer, "README.md", []string{src("README.md")}},
+		{rootLayer, "src/a.js", []string{src("a.js")}},
+		{rootLayer, "a.js", nil},
+	}
+	for _, c := range sourceCases {
+		if got := c.layer.sourcePaths(c.rel); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("sourcePaths(%q) with %+v = %q, want %q", c.rel, c.layer.Mounts, got, c.want)
+		}
+	}
+}
+
+func TestMergerMounts(t *testing.T) {
+	root := tempDir(t)
+	defer os.RemoveAll(root)
+	base, sync := filepath.Join(root, "base"), filepath.Join(root, "sync")
+	writeTree(t, base, map[string]string{"src/a.js": "base", "src/b.js": "base", "package.json": "{}"})
+	writeTree(t, sync, map[string]string{"web/a.js": "sync", "web/static/logo.png": "png", "README.md": "readme"})
+	m := &merger{
+		layers: []mergeLayer{
+			{Dir: base},
+			{Dir: sync, Mounts: mountRules(map[string]string{"web": "src", "web/static": "public"})},
+		},
+		dest:     filepath.Join(root, "merged"),
+		manifest: make(manifest),
+	}
+	if _, err := m.merge(); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"src/a.js":        "sync",
+		"src/b.js":        "base",
+		"package.json":    "{}",
+		"public/logo.png": "png",
+		"README.md":       "readme",
+	}
+	if got := readTree(t, m.dest); !reflect.DeepEqual(got, want) {
+		t.Errorf("merged files = %q, want %q", got, want)
+	}
+
+	// 删除挂载目录中的覆盖文件后恢复base中的版本
+	if err := os.Remove(filepath.Join(sync, "web", "a.js")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.update("src/a.js"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTree(t, m.dest)["src/a.js"]; got != "base" {
+		t.Errorf("src/a.js = %q after removing the overlay, want base", got)
+	}
+}
+
+func TestOverlayLayer(t *testing.T) {
+	root := tempDir(t)
+	defer os.RemoveAll(root)
+	base := filepath.Join(root, "base")
+	writeTree(t, base, map[string]string{"src/a.js": "a"})
+	writeTree(t, filepath.Join(root, "flat"), map[string]string{"a.js": "a"})
+	writeTree(t, filepath.Join(root, "nested"), map[string]string{"src/a.js": "a"})
+
+	defer func(saved map[string]string) { mounts = saved }(mounts)
+	cases := []struct {
+		name   string
+		mounts map[string]string
+		dir    string
+		want   []mountRule
+	}{
+		{"sync without src", nil, "flat", []mountRule{{To: "src"}}},
+		{"sync with src", nil, "nested", nil},
+		{"configured mounts", map[string]string{"lib": "src/lib"}, "flat", []mountRule{{From: "lib", To: "src/lib"}, {}}},
+	}
+	for _, c := range cases {
+		mounts = c.mounts
+		got := overlayLayer(filepath.Join(root, c.dir), base)
+		if !reflect.DeepEqual(got.Mounts, c.want) {
+			t.Errorf("%s: overlayLayer() mounts = %+v, want %+v", c.name, got.Mounts, c.want)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sort"
+)
+
+const (
+	overrideReviewed    = "reviewed"
+	overrideNew         = "not reviewed"
+	overrideBaseChanged = "base changed since review"
+)
+
+// override sync目录中覆盖了base文件的文件
+type override struct {
+	Path     string
+	Base     string
+	Overlay  string
+	BaseHash string
+	Status   string
+	Added    int
+	Removed  int
+	Binary   bool
+}
+
+func (o override) summary() string {
+	switch {
+	case o.Binary:
+		return "binary"
+	case o.Added == 0 && o.Removed == 0:
+		return "identical"
+	default:
+		return fmt.Sprintf("+%d -%d", o.Added, o.Removed)
+	}
+}
+
+// findOverrides 找出所有覆盖了base文件的文件，reviewed记录了审阅时base文件的哈希，配置了合并方式的文件不会覆盖base文件
+func findOverrides(layers []mergeLayer, reviewed map[string]string) ([]override, error) {
+	m := merger{layers: layers}
+	files, err := m.sources()
+	if err != nil {
+		return nil, err
+	}
+	var list []override
+	for rel, srcs := range files {
+		if len(srcs) < 2 || srcs[0].Layer != 0 || mergeStrategy(rel) != nil {
+			continue
+		}
+		o := override{
+			Path:    rel,
+			Base:    srcs[0].Path,
+			Overlay: srcs[len(srcs)-1].Path,
+		}
+		if o.BaseHash, err = hashFile(o.Base); err != nil {
+			return nil, err
+		}
+		switch hash, ok := reviewed[rel]; {
+		case !ok:
+			o.Status = overrideNew
+		case hash != o.BaseHash:
+			o.Status = overrideBaseChanged
+		default:
+			o.Status = overrideReviewed
+		}
+		if err := diffStat(&o); err != nil {
+			return nil, err
+		}
+		list = append(list, o)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Path < list[j].Path
+	})
+	return list, nil
+}
+
+// diffStat 统计覆盖文件相对于base文件增加和删除的行数
+func diffStat(o *override) error {
+	baseData, err := ioutil.ReadFile(o.Base)
+	if err != nil {
+		return err
+	}
+	overlayData, err := ioutil.ReadFile(o.Overlay)
+	if err != nil {
+		return err
+	}
+	if isBinary(baseData) || isBinary(overlayData) {
+		o.Binary = !bytes.Equal(baseData, overlayData)
+		return nil
+	}
+	lines := make(map[string]int)
+	for _, line := range bytes.Split(baseData, []byte("\n")) {
+		lines[string(line)]++
+	}
+	for _, line := range bytes.Split(overlayData, []byte("\n")) {
+		if lines[string(line)] > 0 {
+			lines[string(line)]--
+		} else {
+			o.Added++
+		}
+	}
+	for _, n := range lines {
+		o.Removed += n
+	}
+	return nil
+}
+
+func isBinary(data []byte) bool {
+	if len(data) > 8000 {
+		data = data[:8000]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+// printOverrides 打印覆盖文件列表，onlyPending为true时只打印需要审阅的文件
+func printOverrides(list []override, onlyPending bool) int {
+	pending := 0
+	for _, o := range list {
+		if o.Status == overrideReviewed {
+			if !onlyPending {
+				successPrint("%s %s %s (%s)\n", outputFlag, o.Path, o.summary(), o.Status)
+			}
+			continue
+		}
+		pending++
+		errorPrint("%s %s %s (%s)\n", outputFlag, o.Path, o.summary(), o.Status)
+	}
+	return pending
+}
+
+// listOverrides 列出覆盖文件，ack为true时将其标记为已审阅
+func listOverrides(ack bool, paths []string) error {
+	if isEmptyDir(workBaseDir) {
+		return fmt.Errorf("%s is empty, run `shino up` or `shino update` first", workBaseDir)
+	}
+	lock, err := readLock(lockFile)
+	if err != nil {
+		return err
+	}
+	if lock == nil {
+		lock = &Lock{}
+	}
+	list, err := findOverrides(localLayers(), lock.Overrides)
+	if err != nil {
+		return err
+	}
+	if !ack {
+		pending := printOverrides(list, false)
+		successPrint("%s %d overrides, %d need review\n", outputFlag, len(list), pending)
+		return nil
+	}
+
+	selected := make(map[string]bool)
+	for _, p := range paths {
+		selected[p] = true
+	}
+	if lock.Overrides == nil {
+		lock.Overrides = make(map[string]string)
+	}
+	count := 0
+	for _, o := range list {
+		if len(selected) > 0 && !selected[o.Path] {
+			continue
+		}
+		lock.Overrides[o.Path] = o.BaseHash
+		count++
+	}
+	if err := writeLock(lockFile, lock); err != nil {
+		return err
+	}
+	successPrint("%s %d overrides reviewed\n", outputFlag, count)
+	return nil
+}
+
+// warnOverrides 启动时提示需要审阅的覆盖文件
+func warnOverrides(lock *Lock) {
+	var reviewed map[string]string
+	if lock != nil {
+		reviewed = lock.Overrides
+	}
+	list, err := findOverrides(localLayers(), reviewed)
+	if err != nil {
+		errorPrint("%s check overrides: %v\n", outputFlag, err)
+		return
+	}
+	if pending := printOverrides(list, true); pending > 0 {
+		warnPrint("%s %d overrides need review, run `shino overrides --ack` after checking them\n", outputFlag, pending)
+	}
+}
@@ -1,11 +1,12 @@
 package internal
 
 import (
-	"fmt"
 	"github.com/urfave/cli"
-	"log"
+	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
 )
 
 type (
@@ -50,6 +51,7 @@ type (
 	Config struct {
 		// plugin-specific parameters and secrets
 		Base    string
+		BaseRef string
 		Install string
 		Build   string
 		Sync    string
@@ -239,18 +241,34 @@ func RunDrone() {
 		//
 		cli.StringFlag{
 			Name:   "config.base",
-			Usage:  "base project",
-			Value:  baseURL,
+			Usage:  "base project, defaults to base in kuu.json or " + baseURL,
 			EnvVar: "PLUGIN_BASE",
 		},
+		cli.StringFlag{
+			Name:   "config.base_ref",
+			Usage:  "branch, tag or commit SHA of base project, defaults to ref in kuu.json",
+			EnvVar: "PLUGIN_BASE_REF",
+		},
 		cli.StringFlag{
 			Name:   "sync",
 			Usage:  "sync dir",
 			Value:  syncDir,
 			EnvVar: "PLUGIN_SYNC",
 		},
+		cli.StringFlag{
+			Name:   "log-format",
+			Usage:  "log format, text or json",
+			Value:  logFormatText,
+			EnvVar: "PLUGIN_LOG_FORMAT",
+		},
+		cli.BoolFlag{
+			Name:   "no-color",
+			Usage:  "disable colored output",
+			EnvVar: "PLUGIN_NO_COLOR",
+		},
 	}
-	app.Action = func(c *cli.Context) {
+	app.Before = applyLogFlags
+	app.Action = func(c *cli.Context) error {
 		plugin := Plugin{
 			Repo: Repo{
 				Owner:   c.String("repo.owner"),
@@ -285,49 +303,110 @@ func RunDrone() {
 				},
 			},
 			Config: Config{
-				Base: c.String("config.base"),
-				Sync: c.String("config.sync"),
+				Base:    c.String("config.base"),
+				BaseRef: c.String("config.base_ref"),
+				Sync:    c.String("sync"),
 			},
 		}
 
-		if err := plugin.exec(); err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
+		return plugin.exec()
 	}
 
 	if err := app.Run(os.Args); err != nil {
-		log.Fatal(err)
+		exitWithError(err)
 	}
 }
 
 // Exec 执行插件
 func (p Plugin) exec() error {
-	// 1.备份一次当前目录到/tmp/backup
-	backupDir := path.Join(os.TempDir(), "backup")
-	ensureDir(backupDir)
+	if err := parseConfigFile(); err != nil {
+		return err
+	}
+	// 未配置插件参数时使用kuu.json中的配置
+	if p.Config.Base == "" {
+		p.Config.Base = baseURL
+	}
+	if p.Config.BaseRef == "" {
+		p.Config.BaseRef = baseRef
+	}
+	if p.Config.Sync != "" {
+		syncDir = p.Config.Sync
+	}
+	// 1.备份一次当前目录到临时目录
+	backupDir, err := ioutil.TempDir("", "shino-backup-")
+	if err != nil {
+		return mergeError("create backup dir", err)
+	}
+	defer os.RemoveAll(backupDir)
 	syncDir := syncDir
 	if !path.IsAbs(syncDir) {
 		syncDir = path.Join(cwd(), syncDir)
 	}
-	if stat, err := os.Stat(syncDir); err != nil || !stat.IsDir() {
-		log.Fatal(err)
-	}
 	if err := copyDir(syncDir, backupDir); err != nil {
-		log.Fatal(err)
+		return mergeError("backup "+syncDir, err)
 	}
-	// 2.克隆base到/tmp/base
-	baseDir := path.Join(os.TempDir(), "base")
-	cloneCmd := clone(p.Config.Base, baseDir)
-	execCmd(cloneCmd)
-	// 3.复制base目录到当前目录
-	if err := copyDir(baseDir, cwd()); err != nil {
-		log.Fatal(err)
+	// 2.克隆base到临时目录
+	baseDir, err := ioutil.TempDir("", "shino-base-")
+	if err != nil {
+		return mergeError("create base dir", err)
 	}
-	// 4.复制备份目录到当前目录
-	destPath := destSrcCase(backupDir, cwd())
-	if err := copyDir(backupDir, destPath); err != nil {
-		log.Fatal(err)
+	defer os.RemoveAll(baseDir)
+	lock, err := readLock(lockFile)
+	if err != nil {
+		return configError("read "+lockFile, err)
 	}
+	if err := clone(p.Config.Base, lockedRef(lock, p.Config.Base, p.Config.BaseRef), baseDir); err != nil {
+		return gitError("clone "+p.Config.Base, err)
+	}
+	// 3.克隆git中间层到临时目录
+	layersDir, err := ioutil.TempDir("", "shino-layers-")
+	if err != nil {
+		return mergeError("create layers dir", err)
+	}
+	defer os.RemoveAll(layersDir)
+	mergeLayers, err := pluginLayers(baseDir, layersDir, syncDir, backupDir)
+	if err != nil {
+		return err
+	}
+	// 4.依次合并base目录、中间层和备份目录到当前目录
+	m := merger{
+		layers:   mergeLayers,
+		dest:     cwd(),
+		manifest: make(manifest),
+	}
+	result, err := m.merge()
+	if err != nil {
+		return mergeError("merge dirs", err)
+	}
+	successPrint("%s merged: %s\n", outputFlag, result)
 	return nil
 }
+
+// pluginLayers 插件合并的源目录，顺序与localLayers一致：git中间层克隆到layersDir，位于sync目录中的本地中间层使用备份中的版本
+func pluginLayers(baseDir, layersDir, syncDir, backupDir string) ([]mergeLayer, error) {
+	list := []mergeLayer{{Dir: baseDir}}
+	for _, o := range parseOverlays(layers) {
+		dir := o.Dir
+		if !o.Local {
+			dir = filepath.Join(layersDir, filepath.Base(o.Dir))
+			if err := clone(o.URL, o.Ref, dir); err != nil {
+				return nil, gitError("clone "+o.URL, err)
+			}
+			list = append(list, overlayLayer(dir, baseDir))
+			continue
+		}
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, configError("resolve layer "+dir, err)
+		}
+		if abs == syncDir {
+			continue
+		}
+		// 合并目标为当前目录，sync目录中的文件可能被覆盖
+		if rel, err := filepath.Rel(syncDir, abs); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			dir = filepath.Join(backupDir, rel)
+		}
+		list = append(list, overlayLayer(dir, baseDir))
+	}
+	return append(list, overlayLayer(backupDir, baseDir)), nil
+}
@@ -0,0 +1,75 @@
+package internal
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// 子进程收到SIGTERM后等待其退出的时间，超时后发送SIGKILL
+var stopTimeout = 10 * time.Second
+
+// startProcess 启动子进程，返回的channel在子进程退出时接收其结果
+func startProcess(cmd *exec.Cmd) <-chan error {
+	exited := make(chan error, 1)
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		exited <- err
+		return exited
+	}
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	return exited
+}
+
+// stopProcess 先发送SIGTERM，超时后发送SIGKILL，返回子进程的退出结果
+func stopProcess(cmd *exec.Cmd, exited <-chan error) error {
+	if cmd.Process == nil {
+		return <-exited
+	}
+	if err := terminateProcess(cmd); err != nil {
+		_ = killProcess(cmd)
+	}
+	timer := time.NewTimer(stopTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-exited:
+		return err
+	case <-timer.C:
+		warnPrint("%s %s did not exit in %s, killing\n", outputFlag, cmd.Path, stopTimeout)
+		_ = killProcess(cmd)
+		return <-exited
+	}
+}
+
+// runContext 运行子进程直到其退出，ctx取消时结束整个进程组
+func runContext(ctx context.Context, cmd *exec.Cmd) error {
+	prepareCmd(cmd)
+	exited := startProcess(cmd)
+	select {
+	case err := <-exited:
+		flushOutput(cmd)
+		return err
+	case <-ctx.Done():
+		defer flushOutput(cmd)
+		return stopProcess(cmd, exited)
+	}
+}
+
+// exitCode 返回子进程的退出码，被信号结束时为128加信号值
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			if status.Signaled() {
+				return 128 + int(status.Signal())
+			}
+			return status.ExitStatus()
+		}
+	}
+	return 1
+}
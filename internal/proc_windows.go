@@ -0,0 +1,24 @@
+//go:build windows
+// +build windows
+
+package internal
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup 在独立的进程组中启动子进程
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// terminateProcess Windows不支持SIGTERM，直接结束进程
+func terminateProcess(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+// killProcess 结束子进程
+func killProcess(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,193 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"github.com/fatih/color"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// 进程失败后的处理方式
+const (
+	onFailureRestart = "restart"
+	onFailureIgnore  = "ignore"
+	onFailureStopAll = "stop-all"
+)
+
+var (
+	// 与start命令一起运行的常驻进程
+	processes []processConfig
+
+	// 进程输出前缀的颜色，按顺序循环使用
+	prefixColors = []color.Attribute{color.FgCyan, color.FgMagenta, color.FgYellow, color.FgBlue, color.FgGreen, color.FgHiCyan}
+	// 避免多个进程的输出行交错
+	outputMu sync.Mutex
+)
+
+// processConfig 常驻进程配置
+type processConfig struct {
+	Name    string `json:"name"`
+	Command string `json:"command"`
+	// Cwd 工作目录，相对于Root
+	Cwd string `json:"cwd"`
+	// Root 为“repo”时Cwd相对于当前项目目录，默认相对于合并目录
+	Root string            `json:"root"`
+	Env  map[string]string `json:"env"`
+	// OnFailure 非零退出时的处理方式：restart（默认）、ignore、stop-all
+	OnFailure string `json:"onFailure"`
+	// Ready 启动后的就绪检查
+	Ready *readyConfig `json:"ready"`
+}
+
+// dir 返回进程的工作目录
+func (p processConfig) dir() string {
+	if filepath.IsAbs(p.Cwd) {
+		return p.Cwd
+	}
+	if p.Root == "repo" {
+		return filepath.Join(".", p.Cwd)
+	}
+	return filepath.Join(workMergedDir, p.Cwd)
+}
+
+// checkProcesses 检查进程配置，start命令作为名为“start”的进程最先启动
+func checkProcesses() ([]processConfig, error) {
+	var all []processConfig
+	if startCmd != "" {
+		all = append(all, processConfig{Name: "start", Command: startCmd, Ready: startReady})
+	}
+	names := make(map[string]bool)
+	for _, p := range append(all, processes...) {
+		if strings.TrimSpace(p.Command) == "" {
+			return nil, fmt.Errorf("process %q has no command", p.Name)
+		}
+		if p.Name == "" {
+			return nil, fmt.Errorf("process %q has no name", p.Command)
+		}
+		if names[p.Name] {
+			return nil, fmt.Errorf("duplicate process name: %s", p.Name)
+		}
+		names[p.Name] = true
+		if !shellMode {
+			if _, _, err := parseCommand(p.Command); err != nil {
+				return nil, fmt.Errorf("process %s: %w", p.Name, err)
+			}
+		}
+		if p.Ready != nil {
+			if err := p.Ready.check(); err != nil {
+				return nil, fmt.Errorf("process %s: %w", p.Name, err)
+			}
+		}
+		switch p.OnFailure {
+		case "", onFailureRestart, onFailureIgnore, onFailureStopAll:
+		default:
+			return nil, fmt.Errorf("process %s: unknown onFailure %q", p.Name, p.OnFailure)
+		}
+	}
+	return append(all, processes...), nil
+}
+
+// processGroup 同时守护多个常驻进程
+type processGroup struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	sups   []*supervisor
+
+	mu   sync.Mutex
+	code int
+	// 触发stop-all的进程的退出码优先
+	stopped bool
+}
+
+func newProcessGroup(ctx context.Context, configs []processConfig) *processGroup {
+	ctx, cancel := context.WithCancel(ctx)
+	g := &processGroup{ctx: ctx, cancel: cancel}
+	width := 0
+	for _, p := range configs {
+		if len(p.Name) > width {
+			width = len(p.Name)
+		}
+	}
+	for i, p := range configs {
+		sup := newSupervisor(ctx, p)
+		switch {
+		case logFormat == logFormatJSON:
+			sup.stdout = newLineLogger(logFields{"process": p.Name, "stream": "stdout"})
+			sup.stderr = newLineLogger(logFields{"process": p.Name, "stream": "stderr"})
+		case len(configs) > 1:
+			// 只有一个进程时保持原样输出
+			prefix := color.New(prefixColors[i%len(prefixColors)], color.Bold).Sprintf("%-*s | ", width, p.Name)
+			sup.stdout = newPrefixWriter(os.Stdout, prefix)
+			sup.stderr = newPrefixWriter(os.Stderr, prefix)
+		}
+		if p.Ready != nil {
+			sup.probe = newReadyProbe(p.Ready)
+		}
+		sup.onStop = g.stopAll
+		// 配置了start命令时它总是第一个进程
+		sup.main = i == 0 && startCmd != ""
+		g.sups = append(g.sups, sup)
+	}
+	return g
+}
+
+// run 启动所有进程，全部退出后返回第一个进程或触发stop-all的进程的退出码
+func (g *processGroup) run() int {
+	var wg sync.WaitGroup
+	for i, sup := range g.sups {
+		wg.Add(1)
+		go func(i int, sup *supervisor) {
+			defer wg.Done()
+			code := sup.run()
+			g.mu.Lock()
+			if i == 0 && !g.stopped {
+				g.code = code
+			}
+			g.mu.Unlock()
+		}(i, sup)
+	}
+	go g.awaitReady()
+	wg.Wait()
+	g.cancel()
+	return g.code
+}
+
+// stopAll 进程失败且配置为stop-all时结束所有进程
+func (g *processGroup) stopAll(s *supervisor, code int) {
+	g.mu.Lock()
+	if !g.stopped {
+		g.stopped = true
+		g.code = code
+	}
+	g.mu.Unlock()
+	logWith(levelError, logFields{"process": s.name, "code": code}, "%s %s failed, stopping all processes\n", outputFlag, s.name)
+	g.cancel()
+}
+
+// Restart 重启所有进程
+func (g *processGroup) Restart() {
+	for _, sup := range g.sups {
+		sup.Restart()
+	}
+}
+
+// restartStart 只重启start命令，依赖或配置文件变化时其他常驻进程不受影响
+func (g *processGroup) restartStart() {
+	if len(g.sups) > 0 && g.sups[0].main {
+		g.sups[0].Restart()
+	}
+}
+
+// newPrefixWriter 为每行输出加上前缀
+func newPrefixWriter(w io.Writer, prefix string) *lineWriter {
+	return newLineWriter(func(line []byte) error {
+		outputMu.Lock()
+		defer outputMu.Unlock()
+		_, err := fmt.Fprintf(w, "%s%s\n", prefix, line)
+		return err
+	})
+}
@@ -0,0 +1,267 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultReadyTimeout = 60 * time.Second
+	readyInterval       = 500 * time.Millisecond
+)
+
+var (
+	// start命令的就绪检查
+	startReady *readyConfig
+
+	urlPattern = regexp.MustCompile(`https?://[^\s"'<>]+`)
+)
+
+// readyConfig 就绪检查配置，配置了多项时全部满足才算就绪
+type readyConfig struct {
+	// TCP 端口可以连接，如“localhost:8000”或“:8000”
+	TCP string `json:"tcp"`
+	// HTTP 请求该地址返回200
+	HTTP string `json:"http"`
+	// Log 输出中出现匹配该正则的内容
+	Log string `json:"log"`
+	// Timeout 超时时间，默认60s
+	Timeout string `json:"timeout"`
+}
+
+// check 检查配置是否有效
+func (c *readyConfig) check() error {
+	if c.TCP == "" && c.HTTP == "" && c.Log == "" {
+		return fmt.Errorf("ready requires tcp, http or log")
+	}
+	if c.Log != "" {
+		if _, err := regexp.Compile(c.Log); err != nil {
+			return fmt.Errorf("invalid ready log pattern: %w", err)
+		}
+	}
+	if c.Timeout != "" {
+		if _, err := time.ParseDuration(c.Timeout); err != nil {
+			return fmt.Errorf("invalid ready timeout: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *readyConfig) timeout() time.Duration {
+	if d, err := time.ParseDuration(c.Timeout); err == nil && d > 0 {
+		return d
+	}
+	return defaultReadyTimeout
+}
+
+// readyProbe 检查进程是否就绪
+type readyProbe struct {
+	config  *readyConfig
+	pattern *regexp.Regexp
+
+	mu      sync.Mutex
+	buf     []byte
+	matched chan struct{}
+	logLine string
+}
+
+func newReadyProbe(config *readyConfig) *readyProbe {
+	p := &readyProbe{config: config, matched: make(chan struct{})}
+	if config.Log != "" {
+		p.pattern = regexp.MustCompile(config.Log)
+	}
+	return p
+}
+
+// wrap 在输出的同时检查是否匹配就绪日志
+func (p *readyProbe) wrap(w io.Writer) io.Writer {
+	if p.pattern == nil {
+		return w
+	}
+	return io.MultiWriter(w, writerFunc(p.scan))
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(b []byte) (int, error) {
+	return f(b)
+}
+
+// scan 逐行匹配输出，匹配后不再检查
+func (p *readyProbe) scan(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.logLine != "" {
+		return len(b), nil
+	}
+	p.buf = append(p.buf, b...)
+	for {
+		idx := bytes.IndexByte(p.buf, '\n')
+		if idx < 0 {
+			break
+		}
+		line := stripANSI(string(p.buf[:idx]))
+		p.buf = p.buf[idx+1:]
+		if p.pattern.MatchString(line) {
+			p.logLine = strings.TrimSpace(line)
+			p.buf = nil
+			close(p.matched)
+			break
+		}
+	}
+	return len(b), nil
+}
+
+// wait 在超时时间内轮询直到所有检查都通过
+func (p *readyProbe) wait(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, p.config.timeout())
+	defer cancel()
+	if p.pattern != nil {
+		select {
+		case <-p.matched:
+		case <-ctx.Done():
+			return p.fail(ctx, fmt.Errorf("no output matched %q", p.config.Log))
+		}
+	}
+	ticker := time.NewTicker(readyInterval)
+	defer ticker.Stop()
+	for {
+		err := p.probe(ctx)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return p.fail(ctx, err)
+		}
+	}
+}
+
+func (p *readyProbe) fail(ctx context.Context, err error) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("not ready after %s: %w", p.config.timeout(), err)
+	}
+	return ctx.Err()
+}
+
+// probe 执行一次TCP及HTTP检查
+func (p *readyProbe) probe(ctx context.Context) error {
+	if p.config.TCP != "" {
+		conn, err := net.DialTimeout("tcp", tcpAddr(p.config.TCP), time.Second)
+		if err != nil {
+			return err
+		}
+		conn.Close()
+	}
+	if p.config.HTTP != "" {
+		req, err := http.NewRequest(http.MethodGet, p.config.HTTP, nil)
+		if err != nil {
+			return err
+		}
+		client := http.Client{Timeout: 2 * time.Second}
+		resp, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("GET %s: %s", p.config.HTTP, resp.Status)
+		}
+	}
+	return nil
+}
+
+// url 返回就绪后展示的访问地址
+func (p *readyProbe) url() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case p.config.HTTP != "":
+		return p.config.HTTP
+	case urlPattern.MatchString(p.logLine):
+		return urlPattern.FindString(p.logLine)
+	case p.config.TCP != "":
+		return "http://" + tcpAddr(p.config.TCP)
+	}
+	return ""
+}
+
+// tcpAddr 补全省略的主机名
+func tcpAddr(addr string) string {
+	if strings.HasPrefix(addr, ":") {
+		return "localhost" + addr
+	}
+	return addr
+}
+
+var ansiPattern = regexp.MustCompile("\x1b\\[[0-9;]*[a-zA-Z]")
+
+// stripANSI 去掉输出中的颜色控制字符
+func stripANSI(s string) string {
+	return ansiPattern.ReplaceAllString(s, "")
+}
+
+// readyResult 进程的就绪检查结果
+type readyResult struct {
+	name    string
+	url     string
+	elapsed time.Duration
+	err     error
+}
+
+// awaitReady 等待所有配置了就绪检查的进程，输出就绪地址或失败原因
+func (g *processGroup) awaitReady() {
+	started := time.Now()
+	results := make([]readyResult, len(g.sups))
+	var wg sync.WaitGroup
+	probed := false
+	for i, sup := range g.sups {
+		if sup.probe == nil {
+			continue
+		}
+		probed = true
+		wg.Add(1)
+		go func(i int, sup *supervisor) {
+			defer wg.Done()
+			err := sup.probe.wait(g.ctx)
+			if err != nil && sup.lastError() != nil {
+				err = fmt.Errorf("%v (last exit: %v)", err, sup.lastError())
+			}
+			results[i] = readyResult{name: sup.name, url: sup.probe.url(), elapsed: time.Since(started), err: err}
+		}(i, sup)
+	}
+	if !probed {
+		return
+	}
+	wg.Wait()
+	if g.ctx.Err() != nil {
+		return
+	}
+	line := strings.Repeat("-", 48)
+	successPrint("%s %s\n", outputFlag, line)
+	for i, result := range results {
+		if g.sups[i].probe == nil {
+			continue
+		}
+		fields := logFields{"process": result.name, "url": result.url, "elapsed": result.elapsed.Seconds()}
+		elapsed := result.elapsed.Round(100 * time.Millisecond)
+		if result.err != nil {
+			fields["error"] = result.err.Error()
+			logWith(levelError, fields, "%s %s %v\n", outputFlag, result.name, result.err)
+		} else if result.url != "" {
+			logInfo(fields, "%s %s ready at %s (%s)\n", outputFlag, result.name, result.url, elapsed)
+		} else {
+			logInfo(fields, "%s %s ready (%s)\n", outputFlag, result.name, elapsed)
+		}
+	}
+	successPrint("%s %s\n", outputFlag, line)
+}
@@ -0,0 +1,140 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// 为true时通过系统shell执行install/start命令
+var shellMode = false
+
+// 命令开头的环境变量赋值，如“NODE_ENV=dev”
+var envAssignPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+
+// 未加引号时需要shell执行的操作符
+const shellOperators = "|&;<>"
+
+// splitShellWords 按POSIX shell的规则拆分命令，支持单引号、双引号和反斜杠转义，不展开变量，遇到未加引号的“&&”、管道等操作符时报错
+func splitShellWords(s string) ([]string, error) {
+	var (
+		words   []string
+		word    strings.Builder
+		inWord  bool
+		escaped bool
+		quote   rune
+	)
+	runes := []rune(s)
+	for i, r := range runes {
+		switch {
+		case escaped:
+			// 双引号内的反斜杠只转义特定字符
+			if quote == '"' && !strings.ContainsRune("$`\"\\\n", r) {
+				word.WriteRune('\\')
+			}
+			// 反斜杠加换行表示续行
+			if r != '\n' {
+				word.WriteRune(r)
+				inWord = true
+			}
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\\':
+			escaped = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case strings.ContainsRune(shellOperators, r):
+			op := string(r)
+			if i+1 < len(runes) && runes[i+1] == r && r != ';' {
+				op += op
+			}
+			return nil, fmt.Errorf("shell operator %q requires \"shell\": true in %s: %s", op, configFile, s)
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if escaped && quote == 0 {
+		return nil, fmt.Errorf("unexpected end of command after backslash: %s", s)
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote in command: %s", quote, s)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// parseCommand 解析命令，返回开头的环境变量赋值和命令参数
+func parseCommand(execStr string) (env []string, args []string, err error) {
+	words, err := splitShellWords(execStr)
+	if err != nil {
+		return nil, nil, err
+	}
+	for len(words) > 0 && envAssignPattern.MatchString(words[0]) {
+		env = append(env, words[0])
+		words = words[1:]
+	}
+	if len(words) == 0 {
+		return nil, nil, errors.New("empty command")
+	}
+	return env, words, nil
+}
+
+// shellCmd 通过系统shell执行命令，支持管道、“&&”等shell语法
+func shellCmd(execStr string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", execStr)
+	}
+	return exec.Command("sh", "-c", execStr)
+}
+
+// commandOf 根据shell模式创建命令，并设置配置的环境变量，extra中的变量优先
+func commandOf(execStr string, extra map[string]string) (*exec.Cmd, error) {
+	var (
+		cmd    *exec.Cmd
+		assign []string
+	)
+	if shellMode {
+		cmd = shellCmd(execStr)
+	} else {
+		env, args, err := parseCommand(execStr)
+		if err != nil {
+			return nil, err
+		}
+		cmd = exec.Command(args[0], args[1:]...)
+		assign = env
+	}
+	env, err := commandEnv(extra)
+	if err != nil {
+		return nil, err
+	}
+	// 命令开头的赋值优先于配置的环境变量
+	if env = append(env, assign...); len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	return cmd, nil
+}
@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitShellWords(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"npm run dev", []string{"npm", "run", "dev"}, false},
+		{"  a \t b\nc  ", []string{"a", "b", "c"}, false},
+		{`echo 'hello world'`, []string{"echo", "hello world"}, false},
+		{`echo "hello world"`, []string{"echo", "hello world"}, false},
+		{`echo ''`, []string{"echo", ""}, false},
+		{`echo a"b c"d`, []string{"echo", "ab cd"}, false},
+		{`echo 'a "b"'`, []string{"echo", `a "b"`}, false},
+		{`echo "a 'b'"`, []string{"echo", "a 'b'"}, false},
+		{`echo 'a\b'`, []string{"echo", `a\b`}, false},
+		{`echo "$HOME"`, []string{"echo", "$HOME"}, false},
+		{`echo a\ b`, []string{"echo", "a b"}, false},
+		{`echo \"a\"`, []string{"echo", `"a"`}, false},
+		{`echo "a\"b"`, []string{"echo", `a"b`}, false},
+		{`echo "a\\b"`, []string{"echo", `a\b`}, false},
+		{`echo "a\b"`, []string{"echo", `a\b`}, false},
+		{`echo "\$HOME"`, []string{"echo", "$HOME"}, false},
+		{"echo a\\\nb", []string{"echo", "ab"}, false},
+		{"echo a \\\n b", []string{"echo", "a", "b"}, false},
+		{`echo "a && b"`, []string{"echo", "a && b"}, false},
+		{`echo 'a | b; c > d'`, []string{"echo", "a | b; c > d"}, false},
+		{`echo a\&\&b \; \|`, []string{"echo", "a&&b", ";", "|"}, false},
+		{"npm install && npm start", nil, true},
+		{"npm test || true", nil, true},
+		{"cat a | grep b", nil, true},
+		{"make; make install", nil, true},
+		{"npm start > out.log", nil, true},
+		{"node app.js < in.txt", nil, true},
+		{"npm start &", nil, true},
+		{"a&&b", nil, true},
+		{`echo 'a`, nil, true},
+		{`echo "a`, nil, true},
+		{`echo a\`, nil, true},
+	}
+	for _, c := range cases {
+		got, err := splitShellWords(c.in)
+		if (err != nil) != c.wantErr {
+			t.Errorf("splitShellWords(%q) error = %v, wantErr %v", c.in, err, c.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("splitShellWords(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	cases := []struct {
+		in       string
+		wantEnv  []string
+		wantArgs []string
+		wantErr  bool
+	}{
+		{"npm start", nil, []string{"npm", "start"}, false},
+		{"NODE_ENV=development npm start", []string{"NODE_ENV=development"}, []string{"npm", "start"}, false},
+		{"A=1 B=2 node app.js", []string{"A=1", "B=2"}, []string{"node", "app.js"}, false},
+		{"A= node", []string{"A="}, []string{"node"}, false},
+		{`A="x y" node`, []string{"A=x y"}, []string{"node"}, false},
+		{"A='$HOME' node", []string{"A=$HOME"}, []string{"node"}, false},
+		{"_A1=1 node", []string{"_A1=1"}, []string{"node"}, false},
+		{"node A=1", nil, []string{"node", "A=1"}, false},
+		{"1A=1 node", nil, []string{"1A=1", "node"}, false},
+		{"A-B=1 node", nil, []string{"A-B=1", "node"}, false},
+		{"=1 node", nil, []string{"=1", "node"}, false},
+		{"A=1", nil, nil, true},
+		{"", nil, nil, true},
+		{"A=1 npm start && npm test", nil, nil, true},
+	}
+	for _, c := range cases {
+		env, args, err := parseCommand(c.in)
+		if (err != nil) != c.wantErr {
+			t.Errorf("parseCommand(%q) error = %v, wantErr %v", c.in, err, c.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(env, c.wantEnv) || !reflect.DeepEqual(args, c.wantArgs) {
+			t.Errorf("parseCommand(%q) = %q, %q, want %q, %q", c.in, env, args, c.wantEnv, c.wantArgs)
+		}
+	}
+}
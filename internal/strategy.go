@@ -0,0 +1,152 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// mergeFunc 合并同一路径在多个源目录中的文件，srcs按优先级从低到高排列
+type mergeFunc func(srcs []string) ([]byte, error)
+
+// mergeRule 匹配pattern的文件使用name对应的合并方式
+type mergeRule struct {
+	pattern string
+	name    string
+	matcher *ignoreMatcher
+}
+
+const defaultMergeStrategy = "overwrite"
+
+var (
+	mergeFuncs = make(map[string]mergeFunc)
+	// 默认的合并规则
+	defaultMergeRules = map[string]string{"package.json": "json-deep-merge"}
+	mergeRules        []mergeRule
+)
+
+func init() {
+	// overwrite直接使用优先级最高的文件
+	registerMergeStrategy(defaultMergeStrategy, nil)
+	registerMergeStrategy("json-deep-merge", jsonDeepMerge)
+	registerMergeStrategy("dotenv-merge", dotenvMerge)
+	registerMergeStrategy("append", appendMerge)
+	registerMergeStrategy("skip", skipMerge)
+	mergeRules = newMergeRules(nil)
+}
+
+// registerMergeStrategy 注册合并方式
+func registerMergeStrategy(name string, fn mergeFunc) {
+	mergeFuncs[name] = fn
+}
+
+// newMergeRules 生成合并规则，kuu.json中的规则优先于默认规则，路径更长的规则优先
+func newMergeRules(config map[string]string) []mergeRule {
+	var rules []mergeRule
+	add := func(m map[string]string) {
+		var patterns []string
+		for pattern := range m {
+			patterns = append(patterns, pattern)
+		}
+		sort.Slice(patterns, func(i, j int) bool {
+			if len(patterns[i]) != len(patterns[j]) {
+				return len(patterns[i]) > len(patterns[j])
+			}
+			return patterns[i] < patterns[j]
+		})
+		for _, pattern := range patterns {
+			name := strings.TrimSpace(m[pattern])
+			if _, ok := mergeFuncs[name]; !ok {
+				warnPrint("%s unknown merge strategy %q for %s\n", outputFlag, name, pattern)
+				continue
+			}
+			rules = append(rules, mergeRule{
+				pattern: pattern,
+				name:    name,
+				matcher: newIgnoreMatcher([]string{pattern}),
+			})
+		}
+	}
+	add(config)
+	add(defaultMergeRules)
+	return rules
+}
+
+// mergeStrategy 返回文件的合并方式，nil表示直接使用优先级最高的文件
+func mergeStrategy(rel string) mergeFunc {
+	for _, rule := range mergeRules {
+		if rule.matcher.match(rel, false) {
+			return mergeFuncs[rule.name]
+		}
+	}
+	return mergeFuncs[defaultMergeStrategy]
+}
+
+// skipMerge 忽略上层目录中的文件，保留最下层的版本
+func skipMerge(srcs []string) ([]byte, error) {
+	return ioutil.ReadFile(srcs[0])
+}
+
+// appendMerge 按顺序拼接所有文件的内容
+func appendMerge(srcs []string) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, src := range srcs {
+		data, err := ioutil.ReadFile(src)
+		if err != nil {
+			return nil, err
+		}
+		if buf.Len() > 0 && !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+			buf.WriteByte('\n')
+		}
+		buf.Write(data)
+	}
+	return buf.Bytes(), nil
+}
+
+// dotenvMerge 合并dotenv文件：保留最下层文件的内容及注释，同名变量以上层为准，新增变量追加到末尾
+func dotenvMerge(srcs []string) ([]byte, error) {
+	var lines []string
+	index := make(map[string]int)
+	for i, src := range srcs {
+		data, err := ioutil.ReadFile(src)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			line := scanner.Text()
+			key, ok := dotenvKey(line)
+			switch {
+			case ok && index[key] > 0:
+				lines[index[key]-1] = line
+			case ok:
+				lines = append(lines, line)
+				index[key] = len(lines)
+			case i == 0:
+				// 只保留最下层文件中的注释和空行
+				lines = append(lines, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("read %s: %w", src, err)
+		}
+	}
+	return []byte(strings.Join(lines, "\n") + "\n"), nil
+}
+
+// dotenvKey 解析dotenv中的变量名，注释及空行返回false
+func dotenvKey(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", false
+	}
+	line = strings.TrimPrefix(line, "export ")
+	idx := strings.Index(line, "=")
+	if idx <= 0 {
+		return "", false
+	}
+	return strings.TrimSpace(line[:idx]), true
+}
@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDotenvMerge(t *testing.T) {
+	cases := []struct {
+		name  string
+		files []string
+		want  string
+	}{
+		{
+			"override keeps position",
+			[]string{"A=1\nB=2\n", "A=3\n"},
+			"A=3\nB=2\n",
+		},
+		{
+			"new keys appended",
+			[]string{"A=1\n", "B=2\n", "C=3\n"},
+			"A=1\nB=2\nC=3\n",
+		},
+		{
+			"comments only from base",
+			[]string{"# base\nA=1\n\nB=2\n", "# overlay\nB=3\n"},
+			"# base\nA=1\n\nB=3\n",
+		},
+		{
+			"export prefix",
+			[]string{"export A=1\n", "A=2\n"},
+			"A=2\n",
+		},
+		{
+			"later file wins",
+			[]string{"A=1\n", "A=2\n", "A=3\n"},
+			"A=3\n",
+		},
+		{
+			"no trailing newline",
+			[]string{"A=1", "B=2"},
+			"A=1\nB=2\n",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "shino")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			var srcs []string
+			for i, content := range c.files {
+				src := filepath.Join(dir, string(rune('a'+i))+".env")
+				if err := ioutil.WriteFile(src, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+				srcs = append(srcs, src)
+			}
+			got, err := dotenvMerge(srcs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != c.want {
+				t.Errorf("dotenvMerge(%q) = %q, want %q", c.files, got, c.want)
+			}
+		})
+	}
+}
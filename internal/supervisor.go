@@ -0,0 +1,224 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	minRestartBackoff = time.Second
+	maxRestartBackoff = 30 * time.Second
+	// 进程稳定运行超过该时间后重置退避时间
+	stableRunTime = 10 * time.Second
+)
+
+var (
+	// 合并目录中这些文件变化时重启start命令
+	restartOn = []string{"package.json", ".umirc.js"}
+)
+
+// supervisor 守护常驻进程：非零退出时按失败处理方式重启，也可以手动重启
+type supervisor struct {
+	ctx     context.Context
+	name    string
+	process processConfig
+	restart chan struct{}
+	// 为空时直接输出到终端
+	stdout, stderr io.Writer
+	// 失败处理方式为stop-all的进程失败或start命令无法启动时调用
+	onStop func(s *supervisor, code int)
+	// 为空时不检查是否就绪
+	probe *readyProbe
+	// 是否为start命令，无法启动时结束所有进程
+	main bool
+
+	mu      sync.Mutex
+	lastErr error
+}
+
+func newSupervisor(ctx context.Context, process processConfig) *supervisor {
+	return &supervisor{
+		ctx:     ctx,
+		name:    process.Name,
+		process: process,
+		restart: make(chan struct{}, 1),
+	}
+}
+
+// Restart 请求重启进程，重复的请求会被合并
+func (s *supervisor) Restart() {
+	select {
+	case s.restart <- struct{}{}:
+	default:
+	}
+}
+
+// command 创建进程对应的命令
+func (s *supervisor) command() (*exec.Cmd, error) {
+	cmd, err := commandOf(s.process.Command, s.process.Env)
+	if err != nil {
+		return nil, fmt.Errorf("parse command %q: %w", s.process.Command, err)
+	}
+	cmd.Dir = s.process.dir()
+	prepareCmd(cmd)
+	if s.stdout != nil {
+		cmd.Stdout = s.stdout
+	}
+	if s.stderr != nil {
+		cmd.Stderr = s.stderr
+	}
+	if s.probe != nil {
+		cmd.Stdout = s.probe.wrap(cmd.Stdout)
+	}
+	return cmd, nil
+}
+
+// lastError 返回进程最近一次异常退出的原因
+func (s *supervisor) lastError() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastErr
+}
+
+// flush 输出进程最后不完整的一行
+func (s *supervisor) flush() {
+	for _, w := range []io.Writer{s.stdout, s.stderr} {
+		if f, ok := w.(flusher); ok {
+			_ = f.Flush()
+		}
+	}
+}
+
+// run 守护进程直到收到退出信号，返回进程最后的退出码
+func (s *supervisor) run() int {
+	backoff := minRestartBackoff
+	for {
+		cmd, err := s.command()
+		if err != nil {
+			errorPrint("%s %v\n", outputFlag, err)
+			return 1
+		}
+		started := time.Now()
+		exited := startProcess(cmd)
+
+		select {
+		case <-s.ctx.Done():
+			err := stopProcess(cmd, exited)
+			s.flush()
+			return exitCode(err)
+		case <-s.restart:
+			logInfo(logFields{"process": s.name}, "%s restart %s: %s\n", outputFlag, s.name, maskCommand(s.process.Command))
+			stopProcess(cmd, exited)
+			s.flush()
+			backoff = minRestartBackoff
+			continue
+		case err := <-exited:
+			s.flush()
+			s.mu.Lock()
+			s.lastErr = err
+			s.mu.Unlock()
+			if err == nil {
+				// 正常退出时不自动重启，等待手动重启
+				logInfo(logFields{"process": s.name, "code": 0}, "%s %s exited: %s\n", outputFlag, s.name, maskCommand(s.process.Command))
+				if !s.wait(0) {
+					return 0
+				}
+				continue
+			}
+			if _, ok := err.(*exec.ExitError); !ok {
+				// 命令无法启动（如命令不存在）时自动重启没有意义：start命令及stop-all的进程结束所有进程，其他进程等待手动重启
+				err = commandError("run "+s.name, err)
+				if s.main || s.process.OnFailure == onFailureStopAll {
+					errorPrint("%s %v\n", outputFlag, err)
+					if s.onStop != nil {
+						s.onStop(s, errorExitCode(err))
+					}
+					return errorExitCode(err)
+				}
+				logWith(levelError, logFields{"process": s.name}, "%s %v, press r + Enter to restart\n", outputFlag, err)
+				if !s.wait(0) {
+					return errorExitCode(err)
+				}
+				continue
+			}
+			switch s.process.OnFailure {
+			case onFailureStopAll:
+				if s.onStop != nil {
+					s.onStop(s, exitCode(err))
+				}
+				return exitCode(err)
+			case onFailureIgnore:
+				logWith(levelWarn, logFields{"process": s.name, "code": exitCode(err)}, "%s %s: %v\n", outputFlag, s.name, err)
+				if !s.wait(0) {
+					return exitCode(err)
+				}
+				continue
+			}
+			if time.Since(started) > stableRunTime {
+				backoff = minRestartBackoff
+			}
+			logWith(levelWarn, logFields{"process": s.name, "code": exitCode(err)}, "%s %s: %v, restart in %s\n", outputFlag, s.name, err, backoff)
+			if !s.wait(backoff) {
+				return exitCode(err)
+			}
+			if backoff *= 2; backoff > maxRestartBackoff {
+				backoff = maxRestartBackoff
+			}
+		}
+	}
+}
+
+// wait 等待退避时间或手动重启，delay为0时只等待手动重启，返回false表示已退出
+func (s *supervisor) wait(delay time.Duration) bool {
+	var timeout <-chan time.Time
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-s.ctx.Done():
+		return false
+	case <-s.restart:
+		return true
+	case <-timeout:
+		return true
+	}
+}
+
+// restarter 可以手动重启的进程
+type restarter interface {
+	Restart()
+}
+
+// listenRestartKey 输入“r”并回车时手动重启
+func listenRestartKey(s restarter) {
+	successPrint("%s press r + Enter to restart\n", outputFlag)
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "r" {
+			s.Restart()
+		}
+	}
+}
+
+// needRestart 判断本次同步是否修改了需要重启的文件
+func needRestart(result mergeResult) bool {
+	if len(restartOn) == 0 {
+		return false
+	}
+	m := newIgnoreMatcher(restartOn)
+	for _, change := range result.Changes {
+		if m.match(change.Path, false) {
+			return true
+		}
+	}
+	return false
+}
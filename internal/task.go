@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// kuu.json中配置的任务
+var tasks map[string]taskConfig
+
+// taskConfig 任务配置，也可以直接配置为命令字符串
+type taskConfig struct {
+	Command string `json:"command"`
+	// DependsOn 执行任务前需要依次执行的任务
+	DependsOn []string `json:"dependsOn"`
+}
+
+func (t *taskConfig) UnmarshalJSON(data []byte) error {
+	var command string
+	if err := json.Unmarshal(data, &command); err == nil {
+		t.Command = command
+		return nil
+	}
+	type plain taskConfig
+	return json.Unmarshal(data, (*plain)(t))
+}
+
+// taskOrder 按依赖关系排列需要执行的任务，被依赖的任务在前且只执行一次
+func taskOrder(name string) ([]string, error) {
+	var (
+		order    []string
+		visited  = make(map[string]bool)
+		visiting = make(map[string]bool)
+		visit    func(name string, path []string) error
+	)
+	visit = func(name string, path []string) error {
+		path = append(path, name)
+		if visiting[name] {
+			return fmt.Errorf("circular task dependency: %s", strings.Join(path, " -> "))
+		}
+		if visited[name] {
+			return nil
+		}
+		task, ok := tasks[name]
+		if !ok {
+			return fmt.Errorf("task not found: %s", name)
+		}
+		visiting[name] = true
+		for _, dep := range task.DependsOn {
+			if err := visit(dep, path); err != nil {
+				return err
+			}
+		}
+		visiting[name] = false
+		visited[name] = true
+		order = append(order, name)
+		return nil
+	}
+	if err := visit(name, nil); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// runTask 在合并目录中依次执行任务及其依赖，任一任务失败时停止
+func runTask(ctx context.Context, name string) error {
+	order, err := taskOrder(name)
+	if err != nil {
+		return err
+	}
+	for _, name := range order {
+		command := strings.TrimSpace(tasks[name].Command)
+		if command == "" {
+			continue
+		}
+		logInfo(logFields{"task": name}, "%s run task: %s\n", outputFlag, name)
+		cmd, err := mergedCmd(command)
+		if err == nil {
+			err = runContext(ctx, cmd)
+		}
+		if err != nil {
+			logWith(levelError, logFields{"task": name, "code": errorExitCode(err)}, "%s task %s failed: %v\n", outputFlag, name, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// listTasks 输出所有任务
+func listTasks() {
+	names := make([]string, 0, len(tasks))
+	for name := range tasks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		task := tasks[name]
+		fields := logFields{"task": name, "command": task.Command, "dependsOn": task.DependsOn}
+		if len(task.DependsOn) > 0 {
+			logInfo(fields, "%s: %s (depends on %s)\n", name, task.Command, strings.Join(task.DependsOn, ", "))
+		} else {
+			logInfo(fields, "%s: %s\n", name, task.Command)
+		}
+	}
+}
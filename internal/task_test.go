@@ -0,0 +1,95 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTaskOrder(t *testing.T) {
+	cases := []struct {
+		name    string
+		tasks   map[string]taskConfig
+		run     string
+		want    []string
+		wantErr string
+	}{
+		{
+			name:  "single",
+			tasks: map[string]taskConfig{"build": {Command: "make"}},
+			run:   "build",
+			want:  []string{"build"},
+		},
+		{
+			name: "dependencies first",
+			tasks: map[string]taskConfig{
+				"deploy": {DependsOn: []string{"test", "build"}},
+				"test":   {},
+				"build":  {},
+			},
+			run:  "deploy",
+			want: []string{"test", "build", "deploy"},
+		},
+		{
+			name: "shared dependency runs once",
+			tasks: map[string]taskConfig{
+				"all":   {DependsOn: []string{"a", "b"}},
+				"a":     {DependsOn: []string{"setup"}},
+				"b":     {DependsOn: []string{"setup"}},
+				"setup": {},
+			},
+			run:  "all",
+			want: []string{"setup", "a", "b", "all"},
+		},
+		{
+			name:    "self dependency",
+			tasks:   map[string]taskConfig{"a": {DependsOn: []string{"a"}}},
+			run:     "a",
+			wantErr: "circular task dependency: a -> a",
+		},
+		{
+			name: "circular dependency",
+			tasks: map[string]taskConfig{
+				"a": {DependsOn: []string{"b"}},
+				"b": {DependsOn: []string{"c"}},
+				"c": {DependsOn: []string{"a"}},
+			},
+			run:     "a",
+			wantErr: "circular task dependency: a -> b -> c -> a",
+		},
+		{
+			name: "circular dependency below root",
+			tasks: map[string]taskConfig{
+				"a": {DependsOn: []string{"b"}},
+				"b": {DependsOn: []string{"c"}},
+				"c": {DependsOn: []string{"b"}},
+			},
+			run:     "a",
+			wantErr: "circular task dependency: a -> b -> c -> b",
+		},
+		{
+			name:    "missing task",
+			tasks:   map[string]taskConfig{"a": {DependsOn: []string{"b"}}},
+			run:     "a",
+			wantErr: "task not found: b",
+		},
+	}
+	defer func(saved map[string]taskConfig) { tasks = saved }(tasks)
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tasks = c.tasks
+			got, err := taskOrder(c.run)
+			if c.wantErr != "" {
+				if err == nil || err.Error() != c.wantErr {
+					t.Fatalf("taskOrder(%q) error = %v, want %q", c.run, err, c.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("taskOrder(%q) = %q, want %q", c.run, got, c.want)
+			}
+		})
+	}
+}
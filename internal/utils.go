@@ -1,113 +1,221 @@
 package internal
 
 import (
-	"bytes"
-	"errors"
 	"fmt"
-	"github.com/fatih/color"
 	"io"
 	"io/ioutil"
-	"log"
 	"os"
 	"os/exec"
+	"os/user"
 	"path"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"time"
 )
 
 var (
 	baseURL    = "https://github.com/kuuland/ui.git"
+	baseRef    = ""
 	installCmd = "npm install"
 	startCmd   = "npm start"
 	syncDir    = cwd()
+	// 命令行显式指定了--base-ref时优先于kuu.json中的ref
+	baseRefSet = false
+
+	warnOverridesFlag = false
+	// 复制文件时是否保留源文件的修改时间
+	preserveMtime = false
+	// 文件变更的防抖时间
+	debounce = 300 * time.Millisecond
+	// 输出同步汇总的间隔
+	summaryInterval = 5 * time.Second
 
 	workDir       = path.Join(".shino")
 	workBaseDir   = path.Join(workDir, "base")
 	workMergedDir = path.Join(workDir, "merged")
 
 	outputFlag = "[SHINO]"
+
+	// 完整的commit SHA
+	shaPattern = regexp.MustCompile(`^[0-9a-fA-F]{40}$`)
 )
 
+// copyDir 将源目录中未被忽略的文件复制到目标目录
 func copyDir(srcPath string, destPath string) error {
 	//检测目录正确性
 	if srcInfo, err := os.Stat(srcPath); err != nil {
-		log.Fatal(err)
-	} else {
-		if !srcInfo.IsDir() {
-			log.Fatal(errors.New("srcPath不是一个正确的目录！"))
-		}
+		return err
+	} else if !srcInfo.IsDir() {
+		return fmt.Errorf("%s is not a directory", srcPath)
 	}
 	if destInfo, err := os.Stat(destPath); err != nil {
-		log.Fatal(err)
-	} else {
-		if !destInfo.IsDir() {
-			log.Fatal(errors.New("destInfo不是一个正确的目录！"))
-		}
+		return err
+	} else if !destInfo.IsDir() {
+		return fmt.Errorf("%s is not a directory", destPath)
 	}
 
-	err := filepath.Walk(srcPath, func(path string, f os.FileInfo, err error) error {
+	return filepath.Walk(srcPath, func(path string, f os.FileInfo, err error) error {
 		if f == nil {
 			return err
 		}
 		if !f.IsDir() {
+			if isIgnored(srcPath, path, false) {
+				return nil
+			}
 			p := strings.Replace(path, "\\", "/", -1)
 			destNewPath := strings.Replace(p, srcPath, destPath, -1)
-			if _, err := copyFile(p, destNewPath); err != nil {
-				log.Println(err)
+			// 内容未变化的文件不再重复复制
+			if sameFile(p, destNewPath) {
+				return nil
 			}
-		} else {
-			if err := IsIgnoreDir(path); err != nil {
-				return err
+			if _, err := copyFile(p, destNewPath); err != nil {
+				return fmt.Errorf("copy %s to %s: %w", p, destNewPath, err)
 			}
+		} else if path != srcPath && isIgnored(srcPath, path, true) {
+			return filepath.SkipDir
 		}
 		return nil
 	})
-	if err != nil {
-		log.Fatal(err)
-	}
-	return err
 }
 
-func ensureDir(dir string) {
+func ensureDir(dir string) error {
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
 		if err := os.MkdirAll(dir, 0755); err != nil {
-			log.Println(err)
+			return err
+		}
+	}
+	return nil
+}
+
+// clone 克隆项目到本地目录，ref可以是分支、标签或完整的commit SHA，为空时使用默认分支
+func clone(url, ref, local string) error {
+	if ref == "" {
+		return runCmd(exec.Command("git", "clone", "--depth=1", url, local))
+	}
+	if !isCommitSHA(ref) {
+		return runCmd(exec.Command("git", "clone", "--depth=1", "--branch", ref, url, local))
+	}
+	// 浅克隆无法检出任意commit，先初始化空仓库再按SHA拉取
+	if err := runCmd(exec.Command("git", "init", local)); err != nil {
+		return err
+	}
+	if err := runCmd(gitCmd(local, "remote", "add", "origin", url)); err != nil {
+		return err
+	}
+	return checkoutCommit(local, ref)
+}
+
+// checkoutCommit 拉取并检出指定的commit
+func checkoutCommit(local, sha string) error {
+	if err := runCmd(gitCmd(local, "fetch", "--depth=1", "origin", sha)); err != nil {
+		// 部分服务端不允许直接按SHA拉取，回退为完整拉取
+		errorPrint("%s fetch %s failed, fallback to full fetch\n", outputFlag, sha)
+		if err := runCmd(gitCmd(local, "fetch", "--tags", "origin")); err != nil {
+			return err
 		}
 	}
+	return runCmd(gitCmd(local, "checkout", "--detach", sha))
+}
+
+// updateRef 更新本地仓库到远程ref的最新版本：分支未变化时快进，标签或ref变化时浅拉取后直接检出，commit SHA直接检出
+func updateRef(local, url, ref string, refChanged bool) error {
+	if err := runCmd(gitCmd(local, "remote", "set-url", "origin", url)); err != nil {
+		return err
+	}
+	if isCommitSHA(ref) {
+		return checkoutCommit(local, ref)
+	}
+	fetchRef := ref
+	if fetchRef == "" {
+		fetchRef = "HEAD"
+	}
+	if !refChanged && (ref == "" || isRemoteBranch(local, ref)) {
+		if err := runCmd(gitCmd(local, "fetch", "origin", fetchRef)); err != nil {
+			return err
+		}
+		return runCmd(gitCmd(local, "merge", "--ff-only", "FETCH_HEAD"))
+	}
+	// 浅克隆中切换到无关的历史无法快进合并
+	if err := runCmd(gitCmd(local, "fetch", "--depth=1", "origin", fetchRef)); err != nil {
+		return err
+	}
+	return runCmd(gitCmd(local, "checkout", "--detach", "FETCH_HEAD"))
+}
+
+// isRemoteBranch 判断ref是否为远程仓库的分支
+func isRemoteBranch(local, ref string) bool {
+	return gitCmd(local, "ls-remote", "--exit-code", "--heads", "origin", ref).Run() == nil
+}
+
+// headCommit 获取仓库当前检出的commit
+func headCommit(local string) (string, error) {
+	out, err := gitCmd(local, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "", fmt.Errorf("resolve HEAD of %s: %w", local, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func gitCmd(dir string, args ...string) *exec.Cmd {
+	return exec.Command("git", append([]string{"-C", dir}, args...)...)
+}
+
+func isCommitSHA(ref string) bool {
+	return shaPattern.MatchString(ref)
 }
 
-func clone(url, local string) *exec.Cmd {
-	return exec.Command(
-		"git",
-		"clone",
-		"--depth=1",
-		url,
-		local,
-	)
+func runCmd(cmd *exec.Cmd) error {
+	prepareCmd(cmd)
+	defer flushOutput(cmd)
+	return cmd.Run()
 }
 
-func execCmd(cmd *exec.Cmd) {
-	buf := new(bytes.Buffer)
-	cmd.Stdout = io.MultiWriter(os.Stdout, buf)
-	cmd.Stderr = io.MultiWriter(os.Stderr, buf)
-	logArgs(cmd.Args)
-	if err := cmd.Run(); err != nil {
-		log.Fatal(err)
+// prepareCmd 输出命令并将其输出转发到终端，JSON格式下按行输出为日志
+func prepareCmd(cmd *exec.Cmd) {
+	logCommand(cmd)
+	if logFormat == logFormatJSON {
+		name := filepath.Base(cmd.Args[0])
+		cmd.Stdout = newLineLogger(logFields{"cmd": name, "stream": "stdout"})
+		cmd.Stderr = newLineLogger(logFields{"cmd": name, "stream": "stderr"})
+		return
 	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
 }
 
+// logCommand 输出将要执行的命令及额外的环境变量
+func logCommand(cmd *exec.Cmd) {
+	env := extraEnv(cmd.Env)
+	if logFormat != logFormatJSON {
+		logArgs(append(env, cmd.Args...))
+		return
+	}
+	args := make([]string, len(cmd.Args))
+	for i, arg := range cmd.Args {
+		args[i] = maskArg(arg)
+	}
+	for i, kv := range env {
+		env[i] = maskEnv(kv)
+	}
+	logInfo(logFields{"args": args, "env": env, "dir": cmd.Dir}, "%s run %s", outputFlag, strings.Join(args, " "))
+}
+
+// logArgs 输出命令，环境变量中的敏感信息会被隐藏
 func logArgs(args []string) {
 	output := outputFlag
 	for _, arg := range args {
+		arg = maskArg(arg)
+		if strings.ContainsAny(arg, " \t\n") {
+			arg = fmt.Sprintf("%q", arg)
+		}
 		output = fmt.Sprintf("%s %s", output, arg)
 	}
-	successPrint(fmt.Sprintf("%s\n", output))
+	successPrint("%s\n", output)
 }
 
 func successPrint(format string, a ...interface{}) {
-	if _, err := color.New(color.FgHiGreen, color.Bold).Printf(format, a...); err != nil {
-		log.Println(err)
-	}
+	logWith(levelInfo, nil, format, a...)
 }
 
 func destSrcCase(syncPath, destPath string) string {
@@ -123,75 +231,119 @@ func destSrcCase(syncPath, destPath string) string {
 	return destPath
 }
 
+// cwd 返回程序所在目录，无法获取绝对路径时返回相对路径
 func cwd() string {
-	dir, err := filepath.Abs(filepath.Dir(os.Args[0]))
-	if err != nil {
-		log.Fatal(err)
+	dir := filepath.Dir(os.Args[0])
+	if abs, err := filepath.Abs(dir); err == nil {
+		return abs
 	}
 	return dir
 }
 
-func IsIgnoreDir(path string) error {
-	ignoreDirs := []string{".shino", "node_modules", ".git", ".idea", ".vscode", ".history"}
-	for _, dir := range ignoreDirs {
-		if strings.HasSuffix(path, dir) {
-			return filepath.SkipDir
-		}
-	}
-	return nil
-}
-
 func copyFile(src, dest string) (w int64, err error) {
 	srcFile, err := os.Open(src)
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
 	defer func() {
 		if err := srcFile.Close(); err != nil {
-			log.Println(err)
+			errorPrint("%s close %s: %v\n", outputFlag, src, err)
 		}
 	}()
-	//分割path目录
-	destSplitPathDirs := strings.Split(dest, "/")
-
-	//检测时候存在目录
-	destSplitPath := ""
-	for index, dir := range destSplitPathDirs {
-		if index < len(destSplitPathDirs)-1 {
-			destSplitPath = destSplitPath + dir + "/"
-			b, _ := pathExists(destSplitPath)
-			if b == false {
-				//创建目录
-				err := os.Mkdir(destSplitPath, os.ModePerm)
-				if err != nil {
-					log.Fatal(err)
-				}
-			}
-		}
+	srcInfo, err := srcFile.Stat()
+	if err != nil {
+		return 0, err
+	}
+	var mtime time.Time
+	if preserveMtime {
+		mtime = srcInfo.ModTime()
+	}
+	return writeFileAtomic(dest, srcFile, srcInfo.Mode().Perm(), mtime)
+}
+
+// writeFileAtomic 先写入同目录下的临时文件再重命名，避免其他进程读到写了一半的文件，mtime为零值时不修改
+func writeFileAtomic(dest string, r io.Reader, mode os.FileMode, mtime time.Time) (w int64, err error) {
+	dir := filepath.Dir(dest)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return 0, err
 	}
-	dstFile, err := os.Create(dest)
+	tmpFile, err := ioutil.TempFile(dir, "."+filepath.Base(dest)+".shino-*")
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
+	tmpPath := tmpFile.Name()
 	defer func() {
-		if err := dstFile.Close(); err != nil {
-			log.Println(err)
+		if err != nil {
+			os.Remove(tmpPath)
 		}
 	}()
+	if w, err = io.Copy(tmpFile, r); err != nil {
+		tmpFile.Close()
+		return w, err
+	}
+	if err = tmpFile.Chmod(mode); err != nil {
+		tmpFile.Close()
+		return w, err
+	}
+	if err = tmpFile.Close(); err != nil {
+		return w, err
+	}
+	if !mtime.IsZero() {
+		if err = os.Chtimes(tmpPath, mtime, mtime); err != nil {
+			return w, err
+		}
+	}
+	return w, os.Rename(tmpPath, dest)
+}
 
-	return io.Copy(dstFile, srcFile)
+// sameFile 判断两个文件内容是否一致
+func sameFile(src, dest string) bool {
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return false
+	}
+	destInfo, err := os.Stat(dest)
+	if err != nil || destInfo.IsDir() || srcInfo.Size() != destInfo.Size() {
+		return false
+	}
+	srcSum, err := hashFile(src)
+	if err != nil {
+		return false
+	}
+	destSum, err := hashFile(dest)
+	return err == nil && srcSum == destSum
 }
 
-//检测文件夹路径时候存在
-func pathExists(path string) (bool, error) {
-	_, err := os.Stat(path)
-	if err == nil {
-		return true, nil
+// checkSafeDir 确认目录位于工作目录内，避免误删系统或用户目录
+func checkSafeDir(dir string) error {
+	safeDirs := []string{"", ".", "/", "/usr", os.TempDir()}
+	if v, err := os.UserCacheDir(); err == nil {
+		safeDirs = append(safeDirs, v)
+	}
+	if usr, err := user.Current(); err == nil {
+		safeDirs = append(safeDirs, usr.HomeDir)
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	for _, safeDir := range safeDirs {
+		if dir == safeDir {
+			return fmt.Errorf("unsafe dir: %s", dir)
+		}
+		if absSafeDir, err := filepath.Abs(safeDir); err == nil && absDir == absSafeDir {
+			return fmt.Errorf("unsafe dir: %s", dir)
+		}
 	}
-	if os.IsNotExist(err) {
-		return false, nil
+	absWorkDir, err := filepath.Abs(workDir)
+	if err != nil {
+		return err
 	}
-	return false, err
+	rel, err := filepath.Rel(absWorkDir, absDir)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%s is outside of %s", dir, workDir)
+	}
+	return nil
 }
 
 func isEmptyDir(dirname string) bool {